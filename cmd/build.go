@@ -0,0 +1,176 @@
+/*
+Copyright 2021 Daisuke Taniwaki.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"time"
+
+	"github.com/dtaniwaki/git-kustomize-diff/pkg/gitkustomizediff"
+	"github.com/spf13/pflag"
+	"sigs.k8s.io/kustomize/api/types"
+)
+
+// buildFlags are the flags of the builds and the reports shared by the commands.
+type buildFlags struct {
+	includeRegexpString string
+	excludeRegexpString string
+	leafOnly            bool
+	kustomizePath       string
+	loadRestrictor      string
+	enableHelm          bool
+	helmCommand         string
+	enableAlphaPlugins  bool
+	enableExec          bool
+	helmValuesFile      string
+	builders            []string
+	validate            bool
+	kubeVersion         string
+	crdFiles            []string
+	policyFiles         []string
+	cacheDir            string
+	remoteResources     string
+	remoteMirrorDir     string
+	timeout             time.Duration
+	buildTimeout        time.Duration
+	progress            string
+	timings             bool
+	gitPath             string
+	debug               bool
+}
+
+// addBuildFlags adds the flags of the build options. The debug flag is added
+// by each command.
+func addBuildFlags(flags *pflag.FlagSet, f *buildFlags) {
+	flags.StringVar(&f.includeRegexpString, "include", "", "include regexp (default to all)")
+	flags.StringVar(&f.excludeRegexpString, "exclude", "", "exclude regexp (default to none)")
+	flags.BoolVar(&f.leafOnly, "leaf-only", false, "only diff kustomizations not referred by other kustomizations")
+	flags.StringVar(&f.kustomizePath, "kustomize-path", "", "path of a kustomize binary (default to embeded)")
+	flags.StringVar(&f.loadRestrictor, "load-restrictor", "", "load restrictor of kustomize, LoadRestrictionsRootOnly or LoadRestrictionsNone (default to LoadRestrictionsRootOnly)")
+	flags.BoolVar(&f.enableHelm, "enable-helm", false, "enable the helm chart inflation generator")
+	flags.StringVar(&f.helmCommand, "helm-command", "", "helm command (default to helm)")
+	flags.BoolVar(&f.enableAlphaPlugins, "enable-alpha-plugins", false, "enable kustomize plugins")
+	flags.BoolVar(&f.enableExec, "enable-exec", false, "enable exec function plugins, which requires --enable-alpha-plugins")
+	flags.StringVar(&f.helmValuesFile, "helm-values-file", "", "values file relative to each helm chart, which fails the charts without it")
+	flags.StringSliceVar(&f.builders, "builder", []string{"kustomize"}, "builders in order of precedence (kustomize, helm or yaml)")
+	flags.BoolVar(&f.validate, "validate", false, "validate the target builds against the Kubernetes schemas")
+	flags.StringVar(&f.kubeVersion, "kube-version", "", "Kubernetes version of the schemas to validate with (default to the latest bundled one)")
+	flags.StringSliceVar(&f.crdFiles, "crd-schema", nil, "files of CustomResourceDefinitions to validate custom resources with")
+	flags.StringSliceVar(&f.policyFiles, "policy", nil, "files of CEL policies to check the target builds of the changed kustomizations with")
+	flags.StringVar(&f.cacheDir, "cache-dir", "", "directory to cache the kustomize builds keyed by the inputs (default to no cache)")
+	flags.StringVar(&f.remoteResources, "remote-resources", "fetch", "remote resources of kustomize, fetch, forbid or mirror (fetch once into the remote mirror dir)")
+	flags.StringVar(&f.remoteMirrorDir, "remote-mirror-dir", "", "directory to mirror the remote resources keyed by the URL and the ref")
+	flags.DurationVar(&f.timeout, "timeout", 0, "timeout of the whole run, e.g. 10m (default to no timeout)")
+	flags.DurationVar(&f.buildTimeout, "build-timeout", 0, "timeout of each build, e.g. 1m (default to no timeout)")
+	flags.StringVar(&f.progress, "progress", "auto", "progress on stderr, auto (bar on terminals), bar, json (NDJSON events ending with the timings) or none")
+	flags.BoolVar(&f.timings, "timings", false, "report the durations of the git steps and the slowest kustomizations")
+	flags.StringVar(&f.gitPath, "git-path", "", "path of a git binary (default to git)")
+}
+
+// toDiffOpts converts the flags into the options.
+func (f *buildFlags) toDiffOpts() (gitkustomizediff.DiffOpts, error) {
+	opts := gitkustomizediff.DiffOpts{
+		LeafOnly:           f.leafOnly,
+		KustomizePath:      f.kustomizePath,
+		EnableHelm:         f.enableHelm,
+		HelmCommand:        f.helmCommand,
+		EnableAlphaPlugins: f.enableAlphaPlugins,
+		EnableExec:         f.enableExec,
+		HelmValuesFile:     f.helmValuesFile,
+		Debug:              f.debug,
+		CacheDir:           f.cacheDir,
+		RemoteMirrorDir:    f.remoteMirrorDir,
+		GitPath:            f.gitPath,
+		BuildTimeout:       f.buildTimeout,
+		Logger:             logger,
+	}
+	progress, err := newProgressFunc(f.progress, os.Stderr)
+	if err != nil {
+		return opts, err
+	}
+	opts.Progress = progress
+	if opts.EnableExec && !opts.EnableAlphaPlugins {
+		return opts, fmt.Errorf("--enable-exec requires --enable-alpha-plugins")
+	}
+	remoteResources, err := gitkustomizediff.ParseRemoteResourcesMode(f.remoteResources)
+	if err != nil {
+		return opts, err
+	}
+	opts.RemoteResources = remoteResources
+	if opts.RemoteResources == gitkustomizediff.RemoteResourcesMirror && opts.RemoteMirrorDir == "" {
+		return opts, fmt.Errorf("--remote-mirror-dir is required to mirror the remote resources")
+	}
+	if f.loadRestrictor != "" {
+		loadRestrictions, err := parseLoadRestrictor(f.loadRestrictor)
+		if err != nil {
+			return opts, err
+		}
+		opts.LoadRestrictions = loadRestrictions
+	}
+	for _, name := range f.builders {
+		builder, err := gitkustomizediff.GetBuilder(name)
+		if err != nil {
+			return opts, err
+		}
+		opts.Builders = append(opts.Builders, builder)
+	}
+	if f.validate {
+		validator, err := gitkustomizediff.NewValidator(gitkustomizediff.ValidatorOpts{
+			KubeVersion: f.kubeVersion,
+			CRDFiles:    f.crdFiles,
+		})
+		if err != nil {
+			return opts, err
+		}
+		opts.Validator = validator
+	}
+	if len(f.policyFiles) > 0 {
+		policyChecker, err := gitkustomizediff.NewPolicyChecker(gitkustomizediff.PolicyCheckerOpts{
+			Files: f.policyFiles,
+		})
+		if err != nil {
+			return opts, err
+		}
+		opts.PolicyChecker = policyChecker
+	}
+	if f.includeRegexpString != "" {
+		includeRegexp, err := regexp.Compile(f.includeRegexpString)
+		if err != nil {
+			return opts, err
+		}
+		opts.IncludeRegexp = includeRegexp
+	}
+	if f.excludeRegexpString != "" {
+		excludeRegexp, err := regexp.Compile(f.excludeRegexpString)
+		if err != nil {
+			return opts, err
+		}
+		opts.ExcludeRegexp = excludeRegexp
+	}
+	return opts, nil
+}
+
+func parseLoadRestrictor(value string) (types.LoadRestrictions, error) {
+	for _, loadRestrictions := range []types.LoadRestrictions{types.LoadRestrictionsRootOnly, types.LoadRestrictionsNone} {
+		if value == loadRestrictions.String() {
+			return loadRestrictions, nil
+		}
+	}
+	return types.LoadRestrictionsUnknown, fmt.Errorf("Invalid load restrictor: %s", value)
+}
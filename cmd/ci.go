@@ -0,0 +1,65 @@
+/*
+Copyright 2021 Daisuke Taniwaki.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+
+	"github.com/dtaniwaki/git-kustomize-diff/pkg/gitkustomizediff"
+)
+
+// detectCI returns the CI environment if --ci is set.
+func (f *runFlags) detectCI() (*gitkustomizediff.CIEnv, error) {
+	if !f.ci {
+		return nil, nil
+	}
+	ci := gitkustomizediff.DetectCI(gitkustomizediff.EnvMap(os.Environ()))
+	if ci == nil {
+		return nil, fmt.Errorf("No CI environment is detected")
+	}
+	logger.Infof("Detected %s: base %q, target %q, output %s", ci.Provider, ci.Base, ci.Target, ci.Output)
+	return ci, nil
+}
+
+// newReportWriter returns the writer of the report, which writes to the
+// stdout and the CI output, and the function to flush it.
+func newReportWriter(ci *gitkustomizediff.CIEnv) (io.Writer, func() error, error) {
+	if ci == nil {
+		return os.Stdout, func() error { return nil }, nil
+	}
+	switch ci.Output {
+	case gitkustomizediff.CIOutputFile:
+		f, err := os.OpenFile(ci.OutputFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			return nil, nil, err
+		}
+		return io.MultiWriter(os.Stdout, f), f.Close, nil
+	case gitkustomizediff.CIOutputBuildkiteAnnotation:
+		var buf bytes.Buffer
+		return io.MultiWriter(os.Stdout, &buf), func() error {
+			cmd := exec.Command("buildkite-agent", "annotate", "--context", "git-kustomize-diff", "--style", "info")
+			cmd.Stdin = &buf
+			cmd.Stderr = os.Stderr
+			return cmd.Run()
+		}, nil
+	}
+	return os.Stdout, func() error { return nil }, nil
+}
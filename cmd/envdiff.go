@@ -0,0 +1,155 @@
+/*
+Copyright 2021 Daisuke Taniwaki.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/dtaniwaki/git-kustomize-diff/pkg/gitkustomizediff"
+	"github.com/dtaniwaki/git-kustomize-diff/pkg/utils"
+	"github.com/spf13/cobra"
+)
+
+type envDiffFlags struct {
+	buildFlags
+	commit string
+}
+
+var envDiffCmd = &cobra.Command{
+	Use:   "envdiff dir_pair...",
+	Short: "Diff kustomizations of environments",
+	Long: `Diff pairs of kustomization directories in a tree, e.g. the overlays of environments.
+The directories are given in pairs (envdiff overlays/staging overlays/prod) or
+as a pattern diffing the first directory with the others (envdiff 'overlays/{staging,prod}').`,
+	Args: cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		pairs, err := parseDirPairs(args)
+		if err != nil {
+			return err
+		}
+		opts, err := envDiffOpts.toDiffOpts()
+		if err != nil {
+			return err
+		}
+
+		ctx := context.Background()
+		if envDiffOpts.timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, envDiffOpts.timeout)
+			defer cancel()
+		}
+		dirPath, commit, err := envDiffTree(ctx, &opts)
+		if err != nil {
+			return err
+		}
+		diffMap, err := gitkustomizediff.EnvDiff(ctx, dirPath, pairs, opts)
+		if err != nil {
+			if envDiffOpts.debug {
+				fmt.Fprintln(out, gitkustomizediff.DetailedErrorMessage(err))
+			} else {
+				fmt.Fprintln(out, gitkustomizediff.ConciseErrorMessage(err))
+			}
+			os.Exit(1)
+		}
+
+		printEnvDiffResult(commit, diffMap)
+		if envDiffOpts.timings {
+			printTimings(gitkustomizediff.Timings{Kustomizations: diffMap.KustomizationTimings()}, "##")
+		}
+
+		return nil
+	},
+}
+
+var envDiffOpts envDiffFlags
+
+func init() {
+	envDiffCmd.PersistentFlags().StringVar(&envDiffOpts.commit, "commit", "", "commitish to build from the git objects (default to the work tree)")
+	addBuildFlags(envDiffCmd.PersistentFlags(), &envDiffOpts.buildFlags)
+	envDiffCmd.PersistentFlags().BoolVar(&envDiffOpts.debug, "debug", false, "debug mode (show the full errors)")
+}
+
+// parseDirPairs takes the patterns with a brace and the other directories in pairs.
+func parseDirPairs(args []string) ([]gitkustomizediff.DirPair, error) {
+	pairs := make([]gitkustomizediff.DirPair, 0)
+	dirs := make([]string, 0)
+	for _, arg := range args {
+		if strings.Contains(arg, "{") {
+			expanded, err := gitkustomizediff.ExpandDirPattern(arg)
+			if err != nil {
+				return nil, err
+			}
+			pairs = append(pairs, expanded...)
+			continue
+		}
+		dirs = append(dirs, arg)
+		if len(dirs) == 2 {
+			pairs = append(pairs, gitkustomizediff.DirPair{Base: dirs[0], Target: dirs[1]})
+			dirs = dirs[:0]
+		}
+	}
+	if len(dirs) > 0 {
+		return nil, fmt.Errorf("%s has no directory to diff with", dirs[0])
+	}
+	return pairs, nil
+}
+
+// envDiffTree returns the root of the directories, which is the git tree of
+// the commit if given.
+func envDiffTree(ctx context.Context, opts *gitkustomizediff.DiffOpts) (string, string, error) {
+	if envDiffOpts.commit == "" {
+		dirPath, err := os.Getwd()
+		return dirPath, "", err
+	}
+	if opts.KustomizePath != "" {
+		return "", "", fmt.Errorf("kustomize path cannot be used with a commit")
+	}
+	gitDir := utils.NewGitDir(".", envDiffOpts.gitPath)
+	commit, err := gitDir.CommitHash(ctx, envDiffOpts.commit)
+	if err != nil {
+		return "", "", err
+	}
+	relPath, err := gitDir.RelPath(ctx)
+	if err != nil {
+		return "", "", err
+	}
+	fSys, err := utils.NewGitTreeFs(ctx, gitDir, commit)
+	if err != nil {
+		return "", "", err
+	}
+	opts.BaseFileSystem = fSys
+	opts.TargetFileSystem = fSys
+	return filepath.Join(string(filepath.Separator), relPath), commit, nil
+}
+
+func printEnvDiffResult(commit string, diffMap *gitkustomizediff.DiffMap) {
+	fmt.Fprintf(out, "# Git Kustomize Env Diff\n\n")
+
+	if commit != "" {
+		fmt.Fprintf(out, "%s\n\n", utils.ShortHash(commit))
+	}
+
+	fmt.Fprintf(out, "<details><summary>Target Pairs</summary>\n\n")
+	fmt.Fprintf(out, "```\n%s\n```\n", strings.Join(diffMap.Dirs(), "\n"))
+	fmt.Fprintf(out, "\n</details>\n\n")
+
+	printDiffMap(diffMap, "##")
+}
@@ -0,0 +1,106 @@
+/*
+Copyright 2021 Daisuke Taniwaki.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/dtaniwaki/git-kustomize-diff/pkg/gitkustomizediff"
+	"github.com/dtaniwaki/git-kustomize-diff/pkg/utils"
+	"github.com/spf13/cobra"
+)
+
+var logCmd = &cobra.Command{
+	Use:   "log [target_dir...]",
+	Short: "Run git-kustomize-diff for each commit",
+	Long:  `Run git-kustomize-diff for each commit between the base and the target against its parent`,
+	Args:  cobra.ArbitraryArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ci, err := logOpts.detectCI()
+		if err != nil {
+			return err
+		}
+		opts, err := logOpts.toRunOpts(ci)
+		if err != nil {
+			return err
+		}
+		w, flush, err := newReportWriter(ci)
+		if err != nil {
+			return err
+		}
+		out = w
+
+		dirs := args
+		if len(dirs) == 0 {
+			dirs = []string{"."}
+		}
+		ctx := context.Background()
+		if logOpts.timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, logOpts.timeout)
+			defer cancel()
+		}
+		entries, err := gitkustomizediff.Log(ctx, dirs, opts)
+		if err != nil {
+			if logOpts.debug {
+				fmt.Fprintln(out, gitkustomizediff.DetailedErrorMessage(err))
+			} else {
+				fmt.Fprintln(out, gitkustomizediff.ConciseErrorMessage(err))
+			}
+			_ = flush()
+			os.Exit(1)
+		}
+
+		printLogResult(entries)
+
+		return flush()
+	},
+}
+
+var logOpts runFlags
+
+func init() {
+	addRunFlags(logCmd.PersistentFlags(), &logOpts)
+	// Each commit is diffed against its parent without the local changes.
+	_ = logCmd.PersistentFlags().MarkHidden("allow-dirty")
+}
+
+func printLogResult(entries []gitkustomizediff.LogEntry) {
+	fmt.Fprintf(out, "# Git Kustomize Diff Log\n\n")
+
+	if len(entries) == 0 {
+		fmt.Fprintln(out, "No commits")
+		return
+	}
+	base := utils.ShortHash(entries[0].Result.BaseCommit)
+	if base == "" {
+		base = "(root)"
+	}
+	fmt.Fprintf(out, "%s...%s\n\n", base, utils.ShortHash(entries[len(entries)-1].Commit))
+
+	for _, entry := range entries {
+		fmt.Fprintf(out, "## %s %s\n\n", utils.ShortHash(entry.Result.TargetCommit), entry.Subject)
+		printRisks(entry.Result.DiffMap, "###")
+		printSubmoduleChanges(entry.Result.SubmoduleChanges, "###")
+		printDiffMap(entry.Result.DiffMap, "###")
+		if logOpts.timings {
+			printTimings(entry.Result.Timings(), "###")
+		}
+	}
+}
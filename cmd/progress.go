@@ -0,0 +1,93 @@
+/*
+Copyright 2021 Daisuke Taniwaki.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/dtaniwaki/git-kustomize-diff/pkg/gitkustomizediff"
+	"github.com/dtaniwaki/git-kustomize-diff/pkg/utils"
+)
+
+const progressBarWidth = 30
+
+// newProgressFunc returns the renderer of the progress events in the mode.
+// The auto mode shows a progress bar only if the writer is a terminal.
+func newProgressFunc(mode string, w *os.File) (gitkustomizediff.ProgressFunc, error) {
+	switch mode {
+	case "", "auto":
+		if !isTerminal(w) {
+			return nil, nil
+		}
+		return progressBar(w), nil
+	case "bar":
+		return progressBar(w), nil
+	case "json":
+		return progressJSON(w), nil
+	case "none":
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("Invalid progress mode: %s", mode)
+	}
+}
+
+func isTerminal(f *os.File) bool {
+	st, err := f.Stat()
+	return err == nil && st.Mode()&os.ModeCharDevice != 0
+}
+
+// progressJSON writes the events as NDJSON.
+func progressJSON(w io.Writer) gitkustomizediff.ProgressFunc {
+	encoder := json.NewEncoder(w)
+	return func(event gitkustomizediff.ProgressEvent) {
+		_ = encoder.Encode(event)
+	}
+}
+
+// progressBar overwrites a line with the progress.
+func progressBar(w io.Writer) gitkustomizediff.ProgressFunc {
+	return func(event gitkustomizediff.ProgressEvent) {
+		var status string
+		switch event.Type {
+		case gitkustomizediff.ProgressEventCloneStarted:
+			status = fmt.Sprintf("cloning %s at %s", event.Side, utils.ShortHash(event.Commit))
+		case gitkustomizediff.ProgressEventCloneFinished:
+			status = fmt.Sprintf("cloned %s in %.1fs", event.Side, event.Duration.Seconds())
+		case gitkustomizediff.ProgressEventBuildStarted:
+			status = fmt.Sprintf("building %s", event.Dir)
+		case gitkustomizediff.ProgressEventBuildFinished:
+			status = fmt.Sprintf("built %s in %.1fs", event.Dir, event.Duration.Seconds())
+		case gitkustomizediff.ProgressEventDiffComputed:
+			status = fmt.Sprintf("diffed %s", event.Dir)
+		default:
+			return
+		}
+		filled := 0
+		if event.Total > 0 {
+			filled = progressBarWidth * event.Done / event.Total
+		}
+		bar := strings.Repeat("=", filled) + strings.Repeat(" ", progressBarWidth-filled)
+		fmt.Fprintf(w, "\r\033[K[%s] %d/%d %s", bar, event.Done, event.Total, status)
+		if event.Type == gitkustomizediff.ProgressEventDiffComputed && event.Done == event.Total {
+			fmt.Fprintln(w)
+		}
+	}
+}
@@ -17,7 +17,10 @@ limitations under the License.
 package cmd
 
 import (
-	log "github.com/sirupsen/logrus"
+	"io"
+	"os"
+
+	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 )
 
@@ -35,24 +38,32 @@ var RootCmd = &cobra.Command{
 		}
 		switch rootOpts.verbose {
 		case 0:
-			log.SetLevel(log.ErrorLevel)
+			logger.SetLevel(logrus.ErrorLevel)
 		case 1:
-			log.SetLevel(log.InfoLevel)
+			logger.SetLevel(logrus.InfoLevel)
 		case 2:
-			log.SetLevel(log.DebugLevel)
+			logger.SetLevel(logrus.DebugLevel)
 		case 3:
-			log.SetLevel(log.TraceLevel)
+			logger.SetLevel(logrus.TraceLevel)
 		default:
-			log.SetLevel(log.TraceLevel)
+			logger.SetLevel(logrus.TraceLevel)
 		}
 	},
 }
 
 var rootOpts rootFlags
 
+// logger is the logger of the commands, which writes to stderr.
+var logger = logrus.New()
+
+// out is the writer of the reports, which also writes to the CI output with --ci.
+var out io.Writer = os.Stdout
+
 func init() {
 	cobra.OnInitialize()
 	RootCmd.PersistentFlags().CountVarP(&rootOpts.verbose, "verbose", "v", "verbose mode. (1: info, 2: debug, 3: trace)")
 	RootCmd.AddCommand(versionCmd)
 	RootCmd.AddCommand(runCmd)
+	RootCmd.AddCommand(envDiffCmd)
+	RootCmd.AddCommand(logCmd)
 }
@@ -17,128 +17,345 @@ limitations under the License.
 package cmd
 
 import (
+	"context"
 	"fmt"
 	"os"
-	"regexp"
 	"strings"
+	"time"
 
 	"github.com/dtaniwaki/git-kustomize-diff/pkg/gitkustomizediff"
+	"github.com/dtaniwaki/git-kustomize-diff/pkg/utils"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 )
 
 type runFlags struct {
-	base                string
-	target              string
-	includeRegexpString string
-	excludeRegexpString string
-	kustomizePath       string
-	gitPath             string
-	debug               bool
-	allowDirty          bool
+	buildFlags
+	base           string
+	target         string
+	riskyKinds     []string
+	allowDirty     bool
+	inMemory       bool
+	skipSubmodules bool
+	ci             bool
 }
 
 var runCmd = &cobra.Command{
-	Use:   "run target_dir",
+	Use:   "run [target_dir...]",
 	Short: "Run git-kustomize-diff",
 	Long:  `Run git-kustomize-diff`,
-	Args:  cobra.RangeArgs(0, 1),
+	Args:  cobra.ArbitraryArgs,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		opts := gitkustomizediff.RunOpts{
-			Base:          runOpts.base,
-			Target:        runOpts.target,
-			Debug:         runOpts.debug,
-			AllowDirty:    runOpts.allowDirty,
-			KustomizePath: runOpts.kustomizePath,
-			GitPath:       runOpts.gitPath,
-		}
-		if runOpts.includeRegexpString != "" {
-			includeRegexp, err := regexp.Compile(runOpts.includeRegexpString)
-			if err != nil {
-				return err
-			}
-			opts.IncludeRegexp = includeRegexp
+		ci, err := runOpts.detectCI()
+		if err != nil {
+			return err
 		}
-		if runOpts.excludeRegexpString != "" {
-			excludeRegexp, err := regexp.Compile(runOpts.excludeRegexpString)
-			if err != nil {
-				return err
-			}
-			opts.ExcludeRegexp = excludeRegexp
+		opts, err := runOpts.toRunOpts(ci)
+		if err != nil {
+			return err
 		}
+		w, flush, err := newReportWriter(ci)
+		if err != nil {
+			return err
+		}
+		out = w
 
-		dir := "."
-		if len(args) == 1 {
-			dir = args[0]
+		dirs := args
+		if len(dirs) == 0 {
+			dirs = []string{"."}
+		}
+		ctx := context.Background()
+		if runOpts.timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, runOpts.timeout)
+			defer cancel()
 		}
-		res, err := gitkustomizediff.Run(dir, opts)
+		res, err := gitkustomizediff.Run(ctx, dirs, opts)
 		if err != nil {
-			fmt.Printf("%+v\n", err)
+			if runOpts.debug {
+				fmt.Fprintln(out, gitkustomizediff.DetailedErrorMessage(err))
+			} else {
+				fmt.Fprintln(out, gitkustomizediff.ConciseErrorMessage(err))
+			}
+			_ = flush()
 			os.Exit(1)
 		}
 
-		printRunResult(dir, opts, res)
+		printRunResult(opts, res)
+		if runOpts.timings {
+			printTimings(res.Timings(), "##")
+		}
 
-		return nil
+		return flush()
 	},
 }
 
 var runOpts runFlags
 
+// toRunOpts converts the flags into the options. The base and the target
+// default to the detected ones in CI.
+func (f *runFlags) toRunOpts(ci *gitkustomizediff.CIEnv) (gitkustomizediff.RunOpts, error) {
+	diffOpts, err := f.toDiffOpts()
+	if err != nil {
+		return gitkustomizediff.RunOpts{}, err
+	}
+	opts := gitkustomizediff.RunOpts{
+		Base:               f.base,
+		Target:             f.target,
+		IncludeRegexp:      diffOpts.IncludeRegexp,
+		ExcludeRegexp:      diffOpts.ExcludeRegexp,
+		LeafOnly:           diffOpts.LeafOnly,
+		KustomizePath:      diffOpts.KustomizePath,
+		LoadRestrictions:   diffOpts.LoadRestrictions,
+		EnableHelm:         diffOpts.EnableHelm,
+		HelmCommand:        diffOpts.HelmCommand,
+		EnableAlphaPlugins: diffOpts.EnableAlphaPlugins,
+		EnableExec:         diffOpts.EnableExec,
+		HelmValuesFile:     diffOpts.HelmValuesFile,
+		Builders:           diffOpts.Builders,
+		Validator:          diffOpts.Validator,
+		PolicyChecker:      diffOpts.PolicyChecker,
+		RiskyKinds:         f.riskyKinds,
+		CacheDir:           diffOpts.CacheDir,
+		RemoteResources:    diffOpts.RemoteResources,
+		RemoteMirrorDir:    diffOpts.RemoteMirrorDir,
+		BuildTimeout:       diffOpts.BuildTimeout,
+		GitPath:            diffOpts.GitPath,
+		Debug:              diffOpts.Debug,
+		AllowDirty:         f.allowDirty,
+		InMemory:           f.inMemory,
+		SkipSubmodules:     f.skipSubmodules,
+		Logger:             diffOpts.Logger,
+		Progress:           diffOpts.Progress,
+	}
+	if ci != nil {
+		if opts.Base == "" {
+			opts.Base = ci.Base
+		}
+		if opts.Target == "" {
+			opts.Target = ci.Target
+		}
+	}
+	return opts, nil
+}
+
 func init() {
-	runCmd.PersistentFlags().StringVar(&runOpts.base, "base", "", "base commitish (default to origin/main)")
-	runCmd.PersistentFlags().StringVar(&runOpts.target, "target", "", "target commitish (default to the current branch)")
-	runCmd.PersistentFlags().StringVar(&runOpts.includeRegexpString, "include", "", "include regexp (default to all)")
-	runCmd.PersistentFlags().StringVar(&runOpts.excludeRegexpString, "exclude", "", "exclude regexp (default to none)")
-	runCmd.PersistentFlags().StringVar(&runOpts.kustomizePath, "kustomize-path", "", "path of a kustomize binary (default to embeded)")
-	runCmd.PersistentFlags().StringVar(&runOpts.gitPath, "git-path", "", "path of a git binary (default to git)")
-	runCmd.PersistentFlags().BoolVar(&runOpts.debug, "debug", false, "debug mode")
-	runCmd.PersistentFlags().BoolVar(&runOpts.allowDirty, "allow-dirty", false, "allow dirty tree")
+	addRunFlags(runCmd.PersistentFlags(), &runOpts)
+}
+
+// addRunFlags adds the flags of the run options.
+func addRunFlags(flags *pflag.FlagSet, f *runFlags) {
+	flags.StringVar(&f.base, "base", "", "base commitish (default to origin/main)")
+	flags.StringVar(&f.target, "target", "", "target commitish (default to the current branch)")
+	addBuildFlags(flags, &f.buildFlags)
+	flags.StringSliceVar(&f.riskyKinds, "risky-kinds", gitkustomizediff.DefaultRiskyKinds, "kinds whose deletions are reported as risky changes")
+	flags.BoolVar(&f.debug, "debug", false, "debug mode (keep the cloned repos and show the full errors)")
+	flags.BoolVar(&f.allowDirty, "allow-dirty", false, "allow dirty tree")
+	flags.BoolVar(&f.inMemory, "in-memory", false, "build from git objects without cloning the repo, which requires git 2.38 or later")
+	flags.BoolVar(&f.skipSubmodules, "skip-submodules", false, "don't check out the submodules in the cloned repos")
+	flags.BoolVar(&f.ci, "ci", false, "detect the base, the target and the output in GitHub Actions, GitLab CI, Jenkins or Buildkite")
 }
 
-func printRunResult(dirPath string, opts gitkustomizediff.RunOpts, res *gitkustomizediff.RunResult) {
+func printRunResult(opts gitkustomizediff.RunOpts, res *gitkustomizediff.RunResult) {
 	dirs := res.DiffMap.Dirs()
-	fmt.Printf("# Git Kustomize Diff\n\n")
+	fmt.Fprintf(out, "# Git Kustomize Diff\n\n")
+
+	fmt.Fprintf(out, "%s...%s\n\n", utils.ShortHash(res.BaseCommit), utils.ShortHash(res.TargetCommit))
 
-	fmt.Printf("%s...%s\n\n", res.BaseCommit, res.TargetCommit)
+	printRisks(res.DiffMap, "##")
 
-	fmt.Printf("<details><summary>Options</summary>\n\n")
-	fmt.Println("| name | value |")
-	fmt.Println("|-|-|")
-	fmt.Printf("| dir | %s |\n", dirPath)
-	fmt.Printf("| base | %s |\n", opts.Base)
-	fmt.Printf("| target | %s |\n", opts.Target)
+	fmt.Fprintf(out, "<details><summary>Options</summary>\n\n")
+	fmt.Fprintln(out, "| name | value |")
+	fmt.Fprintln(out, "|-|-|")
+	fmt.Fprintf(out, "| dirs | %s |\n", strings.Join(res.Dirs, ", "))
+	fmt.Fprintf(out, "| base | %s |\n", opts.Base)
+	fmt.Fprintf(out, "| target | %s |\n", opts.Target)
 	includeRegexp := ""
 	if opts.IncludeRegexp != nil {
 		includeRegexp = opts.IncludeRegexp.String()
 	}
-	fmt.Printf("| include | %s |\n", strings.ReplaceAll(includeRegexp, "|", "\\|"))
+	fmt.Fprintf(out, "| include | %s |\n", strings.ReplaceAll(includeRegexp, "|", "\\|"))
 	excludeRegexp := ""
 	if opts.ExcludeRegexp != nil {
 		excludeRegexp = opts.ExcludeRegexp.String()
 	}
-	fmt.Printf("| exclude | %s |\n", strings.ReplaceAll(excludeRegexp, "|", "\\|"))
-	fmt.Printf("\n</details>\n\n")
+	fmt.Fprintf(out, "| exclude | %s |\n", strings.ReplaceAll(excludeRegexp, "|", "\\|"))
+	fmt.Fprintf(out, "| leaf only | %t |\n", opts.LeafOnly)
+	if opts.Validator != nil {
+		fmt.Fprintf(out, "| schema | %s |\n", opts.Validator.KubeVersion())
+	}
+	if opts.PolicyChecker != nil {
+		fmt.Fprintf(out, "| policies | %s |\n", strings.Join(opts.PolicyChecker.Names(), ", "))
+	}
+	fmt.Fprintf(out, "\n</details>\n\n")
 
-	fmt.Printf("<details><summary>Target Kustomizations</summary>\n\n")
+	fmt.Fprintf(out, "<details><summary>Target Kustomizations</summary>\n\n")
 	if len(dirs) > 0 {
-		fmt.Printf("```\n%s\n```\n", strings.Join(dirs, "\n"))
+		fmt.Fprintf(out, "```\n%s\n```\n", strings.Join(dirs, "\n"))
 	} else {
-		fmt.Println("N/A")
+		fmt.Fprintln(out, "N/A")
+	}
+	fmt.Fprintf(out, "\n</details>\n\n")
+
+	printSubmoduleChanges(res.SubmoduleChanges, "##")
+	printDiffMap(res.DiffMap, "##")
+}
+
+func printSubmoduleChanges(changes []utils.SubmoduleChange, heading string) {
+	if len(changes) == 0 {
+		return
+	}
+	fmt.Fprintf(out, "%s Submodule Changes\n\n", heading)
+	fmt.Fprintln(out, "| submodule | commit |")
+	fmt.Fprintln(out, "|-|-|")
+	for _, change := range changes {
+		fmt.Fprintf(out, "| %s | %s → %s |\n", change.Path, markdownCommit(change.Old), markdownCommit(change.New))
+	}
+	fmt.Fprintln(out)
+}
+
+// printRisks prints the risky changes first to draw attention. The sections
+// are printed with the heading, e.g. "##".
+func printRisks(diffMap *gitkustomizediff.DiffMap, heading string) {
+	dirs := diffMap.Dirs()
+	riskRows := make([]string, 0)
+	for _, dir := range dirs {
+		for _, risk := range diffMap.Risks[dir] {
+			riskRows = append(riskRows, fmt.Sprintf("| %s | %s | %s | %s |", dir, risk.Resource, risk.Category, risk.Message))
+		}
+	}
+	if len(riskRows) > 0 {
+		fmt.Fprintf(out, "%s :rotating_light: Risky Changes\n\n", heading)
+		fmt.Fprintln(out, "| kustomization | resource | risk | detail |")
+		fmt.Fprintln(out, "|-|-|-|-|")
+		fmt.Fprintf(out, "%s\n\n", strings.Join(riskRows, "\n"))
+	}
+}
+
+// printDiffMap prints the changes and the results of the directories.
+func printDiffMap(diffMap *gitkustomizediff.DiffMap, heading string) {
+	dirs := diffMap.Dirs()
+	imageRows := make([]string, 0)
+	for _, dir := range dirs {
+		for _, change := range diffMap.ImageChanges[dir] {
+			imageRows = append(imageRows, fmt.Sprintf("| %s | %s | %s | %s → %s |", dir, change.Resource, change.Container, markdownImage(change.Old), markdownImage(change.New)))
+		}
+	}
+	if len(imageRows) > 0 {
+		fmt.Fprintf(out, "%s Image Changes\n\n", heading)
+		fmt.Fprintln(out, "| kustomization | resource | container | image |")
+		fmt.Fprintln(out, "|-|-|-|-|")
+		fmt.Fprintf(out, "%s\n\n", strings.Join(imageRows, "\n"))
+	}
+
+	statusRows := make([]string, 0)
+	for _, status := range []gitkustomizediff.BuildStatus{
+		gitkustomizediff.BuildStatusNewlyBroken,
+		gitkustomizediff.BuildStatusFixed,
+		gitkustomizediff.BuildStatusBrokenOnBoth,
+		gitkustomizediff.BuildStatusTimedOut,
+	} {
+		if statusDirs := diffMap.DirsByBuildStatus(status); len(statusDirs) > 0 {
+			statusRows = append(statusRows, fmt.Sprintf("| %s | %s |", diffMap.StatusLabel(status), strings.Join(statusDirs, ", ")))
+		}
+	}
+	if len(statusRows) > 0 {
+		fmt.Fprintf(out, "%s Build Status\n\n", heading)
+		fmt.Fprintln(out, "| status | kustomizations |")
+		fmt.Fprintln(out, "|-|-|")
+		fmt.Fprintf(out, "%s\n\n", strings.Join(statusRows, "\n"))
+	}
+
+	if len(diffMap.Warnings) > 0 {
+		fmt.Fprintf(out, "%s Warnings\n\n", heading)
+		for _, warning := range diffMap.Warnings {
+			fmt.Fprintf(out, "- :warning: %s\n", warning)
+		}
+		fmt.Fprintln(out)
 	}
-	fmt.Printf("\n</details>\n\n")
 
 	found := false
 	for _, dir := range dirs {
-		text := res.DiffMap.Results[dir].AsMarkdown()
+		text := diffMap.Results[dir].AsMarkdown()
+		validationErrors := diffMap.Validations[dir]
+		violations := diffMap.Violations[dir]
+		immutableFieldChanges := diffMap.ImmutableFieldChanges[dir]
+		if text == "" && len(validationErrors) == 0 && len(violations) == 0 && len(immutableFieldChanges) == 0 {
+			continue
+		}
+		fmt.Fprintf(out, "%s %s\n\n", heading, dir)
 		if text != "" {
-			fmt.Printf("## %s\n\n", dir)
-			fmt.Printf("<details><summary>diff</summary>\n\n")
-			fmt.Println(text)
-			fmt.Printf("\n</details>\n\n")
-			found = true
+			fmt.Fprintf(out, "<details><summary>diff</summary>\n\n")
+			fmt.Fprintln(out, text)
+			fmt.Fprintf(out, "\n</details>\n\n")
+		}
+		if len(validationErrors) > 0 {
+			fmt.Fprintf(out, ":warning: %d schema validation error(s)\n\n", len(validationErrors))
+			fmt.Fprintf(out, "```\n%s\n```\n\n", strings.Join(validationErrors, "\n"))
+		}
+		if len(immutableFieldChanges) > 0 {
+			fmt.Fprintf(out, ":recycle: %d change(s) of immutable fields\n\n", len(immutableFieldChanges))
+			for _, change := range immutableFieldChanges {
+				fmt.Fprintf(out, "- %s\n", change)
+			}
+			fmt.Fprintln(out)
+		}
+		if len(violations) > 0 {
+			fmt.Fprintf(out, ":no_entry: %d policy violation(s)\n\n", len(violations))
+			for _, violation := range violations {
+				fmt.Fprintf(out, "- %s\n", violation)
+			}
+			fmt.Fprintln(out)
 		}
+		found = true
 	}
 	if !found {
-		fmt.Println(":tada::tada: No Diff :tada::tada:")
+		fmt.Fprintln(out, ":tada::tada: No Diff :tada::tada:")
+	}
+}
+
+// slowestKustomizations is the number of the kustomizations in the timings report.
+const slowestKustomizations = 10
+
+func printTimings(timings gitkustomizediff.Timings, heading string) {
+	fmt.Fprintf(out, "%s Timings\n\n", heading)
+	if len(timings.GitSteps) > 0 {
+		fmt.Fprintf(out, "<details><summary>Git steps</summary>\n\n")
+		fmt.Fprintln(out, "| step | duration |")
+		fmt.Fprintln(out, "|-|-|")
+		for _, step := range timings.GitSteps {
+			fmt.Fprintf(out, "| %s | %s |\n", step.Step, formatDuration(step.Duration))
+		}
+		fmt.Fprintf(out, "\n</details>\n\n")
+	}
+
+	kustomizations := timings.Kustomizations
+	if len(kustomizations) > slowestKustomizations {
+		kustomizations = kustomizations[:slowestKustomizations]
+	}
+	fmt.Fprintf(out, "Slowest kustomizations\n\n")
+	fmt.Fprintln(out, "| kustomization | base build | target build | diff | total |")
+	fmt.Fprintln(out, "|-|-|-|-|-|")
+	for _, timing := range kustomizations {
+		fmt.Fprintf(out, "| %s | %s | %s | %s | %s |\n", timing.Dir, formatDuration(timing.BaseBuild), formatDuration(timing.TargetBuild), formatDuration(timing.Diff), formatDuration(timing.Total()))
+	}
+	fmt.Fprintln(out)
+}
+
+func formatDuration(d time.Duration) string {
+	return d.Round(time.Millisecond).String()
+}
+
+func markdownCommit(commit string) string {
+	if commit == "" {
+		return "(none)"
+	}
+	return fmt.Sprintf("`%s`", utils.ShortHash(commit))
+}
+
+func markdownImage(image string) string {
+	if image == "" {
+		return "(none)"
 	}
+	return fmt.Sprintf("`%s`", image)
 }
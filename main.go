@@ -20,14 +20,8 @@ import (
 	"os"
 
 	"github.com/dtaniwaki/git-kustomize-diff/cmd"
-
-	log "github.com/sirupsen/logrus"
 )
 
-func init() {
-	log.SetOutput(os.Stderr)
-}
-
 func main() {
 	if err := cmd.RootCmd.Execute(); err != nil {
 		os.Exit(-1)
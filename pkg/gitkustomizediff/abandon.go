@@ -0,0 +1,189 @@
+/*
+Copyright 2021 Daisuke Taniwaki.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gitkustomizediff
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/pkg/errors"
+	"sigs.k8s.io/kustomize/kyaml/filesys"
+)
+
+var errBuildAbandoned = errors.New("the build is abandoned")
+
+// abandonableFs is the file system of a build left running in the background.
+// Once the build is abandoned, it fails the accesses to the underlying file
+// system so that the build doesn't read it while the later builds use it.
+type abandonableFs struct {
+	fSys      filesys.FileSystem
+	mu        sync.Mutex
+	cond      *sync.Cond
+	active    int
+	abandoned bool
+}
+
+func newAbandonableFs(fSys filesys.FileSystem) *abandonableFs {
+	afs := &abandonableFs{fSys: fSys}
+	afs.cond = sync.NewCond(&afs.mu)
+	return afs
+}
+
+// abandon waits for the ongoing accesses and fails the later ones.
+func (afs *abandonableFs) abandon() {
+	afs.mu.Lock()
+	defer afs.mu.Unlock()
+	afs.abandoned = true
+	for afs.active > 0 {
+		afs.cond.Wait()
+	}
+}
+
+// enter starts an access, which can be nested in a walk, unless abandoned.
+func (afs *abandonableFs) enter() error {
+	afs.mu.Lock()
+	defer afs.mu.Unlock()
+	if afs.abandoned {
+		return errors.WithStack(errBuildAbandoned)
+	}
+	afs.active++
+	return nil
+}
+
+func (afs *abandonableFs) leave() {
+	afs.mu.Lock()
+	defer afs.mu.Unlock()
+	afs.active--
+	if afs.active == 0 {
+		afs.cond.Broadcast()
+	}
+}
+
+func (afs *abandonableFs) Create(path string) (filesys.File, error) {
+	if err := afs.enter(); err != nil {
+		return nil, err
+	}
+	defer afs.leave()
+	return afs.fSys.Create(path)
+}
+
+func (afs *abandonableFs) Mkdir(path string) error {
+	if err := afs.enter(); err != nil {
+		return err
+	}
+	defer afs.leave()
+	return afs.fSys.Mkdir(path)
+}
+
+func (afs *abandonableFs) MkdirAll(path string) error {
+	if err := afs.enter(); err != nil {
+		return err
+	}
+	defer afs.leave()
+	return afs.fSys.MkdirAll(path)
+}
+
+func (afs *abandonableFs) RemoveAll(path string) error {
+	if err := afs.enter(); err != nil {
+		return err
+	}
+	defer afs.leave()
+	return afs.fSys.RemoveAll(path)
+}
+
+func (afs *abandonableFs) Open(path string) (filesys.File, error) {
+	if err := afs.enter(); err != nil {
+		return nil, err
+	}
+	defer afs.leave()
+	return afs.fSys.Open(path)
+}
+
+func (afs *abandonableFs) IsDir(path string) bool {
+	if err := afs.enter(); err != nil {
+		return false
+	}
+	defer afs.leave()
+	return afs.fSys.IsDir(path)
+}
+
+func (afs *abandonableFs) ReadDir(path string) ([]string, error) {
+	if err := afs.enter(); err != nil {
+		return nil, err
+	}
+	defer afs.leave()
+	return afs.fSys.ReadDir(path)
+}
+
+func (afs *abandonableFs) CleanedAbs(path string) (filesys.ConfirmedDir, string, error) {
+	if err := afs.enter(); err != nil {
+		return "", "", err
+	}
+	defer afs.leave()
+	return afs.fSys.CleanedAbs(path)
+}
+
+func (afs *abandonableFs) Exists(path string) bool {
+	if err := afs.enter(); err != nil {
+		return false
+	}
+	defer afs.leave()
+	return afs.fSys.Exists(path)
+}
+
+func (afs *abandonableFs) Glob(pattern string) ([]string, error) {
+	if err := afs.enter(); err != nil {
+		return nil, err
+	}
+	defer afs.leave()
+	return afs.fSys.Glob(pattern)
+}
+
+func (afs *abandonableFs) ReadFile(path string) ([]byte, error) {
+	if err := afs.enter(); err != nil {
+		return nil, err
+	}
+	defer afs.leave()
+	return afs.fSys.ReadFile(path)
+}
+
+func (afs *abandonableFs) WriteFile(path string, data []byte) error {
+	if err := afs.enter(); err != nil {
+		return err
+	}
+	defer afs.leave()
+	return afs.fSys.WriteFile(path, data)
+}
+
+// Walk stops calling walkFn once abandoned, which lets abandon return without
+// waiting for the whole walk.
+func (afs *abandonableFs) Walk(path string, walkFn filepath.WalkFunc) error {
+	if err := afs.enter(); err != nil {
+		return err
+	}
+	defer afs.leave()
+	return afs.fSys.Walk(path, func(path string, info os.FileInfo, err error) error {
+		afs.mu.Lock()
+		abandoned := afs.abandoned
+		afs.mu.Unlock()
+		if abandoned {
+			return errors.WithStack(errBuildAbandoned)
+		}
+		return walkFn(path, info, err)
+	})
+}
@@ -0,0 +1,72 @@
+/*
+Copyright 2021 Daisuke Taniwaki.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gitkustomizediff
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"sigs.k8s.io/kustomize/kyaml/filesys"
+)
+
+// blockingFs blocks reading the files until released.
+type blockingFs struct {
+	filesys.FileSystem
+	reading chan struct{}
+	release chan struct{}
+}
+
+func (bfs *blockingFs) ReadFile(path string) ([]byte, error) {
+	bfs.reading <- struct{}{}
+	<-bfs.release
+	return bfs.FileSystem.ReadFile(path)
+}
+
+func TestAbandonableFs(t *testing.T) {
+	fSys := filesys.MakeFsInMemory()
+	if !assert.NoError(t, fSys.WriteFile("/a/kustomization.yaml", []byte("resources: []\n"))) {
+		t.FailNow()
+	}
+	bfs := &blockingFs{FileSystem: fSys, reading: make(chan struct{}), release: make(chan struct{})}
+	afs := newAbandonableFs(bfs)
+	assert.True(t, afs.IsDir("/a"))
+
+	go func() {
+		_, _ = afs.ReadFile("/a/kustomization.yaml")
+	}()
+	<-bfs.reading
+	abandoned := make(chan struct{})
+	go func() {
+		afs.abandon()
+		close(abandoned)
+	}()
+	// The ongoing access is waited for.
+	select {
+	case <-abandoned:
+		t.Fatal("abandoned during an access")
+	case <-time.After(100 * time.Millisecond):
+	}
+	close(bfs.release)
+	<-abandoned
+
+	_, err := afs.ReadFile("/a/kustomization.yaml")
+	assert.True(t, errors.Is(err, errBuildAbandoned))
+	assert.False(t, afs.IsDir("/a"))
+	assert.Error(t, afs.Walk("/a", nil))
+}
@@ -0,0 +1,248 @@
+/*
+Copyright 2021 Daisuke Taniwaki.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gitkustomizediff
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/dtaniwaki/git-kustomize-diff/pkg/utils"
+	"github.com/pkg/errors"
+	"sigs.k8s.io/kustomize/kyaml/filesys"
+)
+
+// Builder renders the manifests of a directory.
+type Builder interface {
+	// Name returns the name of the builder.
+	Name() string
+	// Detect returns true if the builder can build the directory in the root
+	// directory of the listing.
+	Detect(fSys filesys.FileSystem, rootPath, dirPath string) bool
+	// Build renders the manifests of the directory as YAML.
+	Build(ctx context.Context, dirPath string, opts BuildOpts) (string, error)
+}
+
+var (
+	_ Builder = &KustomizeBuilder{}
+	_ Builder = &HelmBuilder{}
+	_ Builder = &YamlBuilder{}
+)
+
+// DefaultBuilders are used when no builder is specified.
+var DefaultBuilders = []Builder{&KustomizeBuilder{}}
+
+// GetBuilder returns the builder of the name.
+func GetBuilder(name string) (Builder, error) {
+	for _, builder := range []Builder{&KustomizeBuilder{}, &HelmBuilder{}, &YamlBuilder{}} {
+		if builder.Name() == name {
+			return builder, nil
+		}
+	}
+	return nil, fmt.Errorf("Unknown builder: %s", name)
+}
+
+// detectBuilder returns the first builder which can build the directory, or nil.
+func detectBuilder(builders []Builder, fSys filesys.FileSystem, rootPath, dirPath string) Builder {
+	for _, builder := range builders {
+		if builder.Detect(fSys, rootPath, dirPath) {
+			return builder
+		}
+	}
+	return nil
+}
+
+// KustomizeBuilder builds a directory with a kustomization file.
+type KustomizeBuilder struct{}
+
+func (b *KustomizeBuilder) Name() string {
+	return "kustomize"
+}
+
+func (b *KustomizeBuilder) Detect(fSys filesys.FileSystem, rootPath, dirPath string) bool {
+	return utils.KustomizationExistsInFs(fSys, dirPath)
+}
+
+func (b *KustomizeBuilder) Build(ctx context.Context, dirPath string, opts BuildOpts) (string, error) {
+	return Build(ctx, dirPath, opts)
+}
+
+// HelmBuilder builds a helm chart with `helm template`.
+type HelmBuilder struct{}
+
+func (b *HelmBuilder) Name() string {
+	return "helm"
+}
+
+func (b *HelmBuilder) Detect(fSys filesys.FileSystem, rootPath, dirPath string) bool {
+	return fSys.Exists(filepath.Join(dirPath, "Chart.yaml"))
+}
+
+func (b *HelmBuilder) Build(ctx context.Context, dirPath string, opts BuildOpts) (string, error) {
+	if opts.FileSystem != nil {
+		// helm can only read charts on disk.
+		tmpDirPath, err := ioutil.TempDir("", "git-kustomize-diff-helm-")
+		if err != nil {
+			return "", errors.WithStack(err)
+		}
+		defer os.RemoveAll(tmpDirPath)
+		chartDirPath := filepath.Join(tmpDirPath, filepath.Base(dirPath))
+		err = copyDirToDisk(opts.FileSystem, dirPath, chartDirPath)
+		if err != nil {
+			return "", err
+		}
+		opts.FileSystem = nil
+		return b.Build(ctx, chartDirPath, opts)
+	}
+	helmCommand := opts.HelmCommand
+	if helmCommand == "" {
+		helmCommand = "helm"
+	}
+	args := []string{"template", filepath.Base(dirPath), dirPath}
+	if opts.HelmValuesFile != "" {
+		valuesFilePath := filepath.Join(dirPath, opts.HelmValuesFile)
+		if !utils.Exists(valuesFilePath) {
+			return "", errors.Errorf("helm values file %s doesn't exist in %s", opts.HelmValuesFile, filepath.Base(dirPath))
+		}
+		args = append(args, "--values", valuesFilePath)
+	}
+	stdout, _, err := (&utils.WorkDir{}).RunCommand(ctx, helmCommand, args...)
+	if err != nil {
+		return "", err
+	}
+	return stdout, nil
+}
+
+// YamlBuilder concatenates the plain YAML files of Kubernetes objects in a
+// directory. Other YAML files like CI configs, kustomization files and
+// directories in helm charts under the root directory are ignored.
+type YamlBuilder struct{}
+
+func (b *YamlBuilder) Name() string {
+	return "yaml"
+}
+
+func (b *YamlBuilder) Detect(fSys filesys.FileSystem, rootPath, dirPath string) bool {
+	rootPath = filepath.Clean(rootPath)
+	for dir := filepath.Clean(dirPath); ; dir = filepath.Dir(dir) {
+		if fSys.Exists(filepath.Join(dir, "Chart.yaml")) {
+			return false
+		}
+		if dir == rootPath || dir == filepath.Dir(dir) {
+			break
+		}
+	}
+	files, err := b.listFiles(fSys, dirPath)
+	return err == nil && len(files) > 0
+}
+
+func (b *YamlBuilder) Build(ctx context.Context, dirPath string, opts BuildOpts) (string, error) {
+	if ctx.Err() != nil {
+		return "", errors.WithStack(ctx.Err())
+	}
+	fSys := opts.FileSystem
+	if fSys == nil {
+		fSys = filesys.MakeFsOnDisk()
+	}
+	files, err := b.listFiles(fSys, dirPath)
+	if err != nil {
+		return "", err
+	}
+	docs := make([]string, 0, len(files))
+	for _, file := range files {
+		bs, err := fSys.ReadFile(file)
+		if err != nil {
+			return "", errors.WithStack(err)
+		}
+		doc := strings.TrimPrefix(strings.TrimSpace(string(bs)), "---\n")
+		if doc != "" {
+			docs = append(docs, doc+"\n")
+		}
+	}
+	return strings.Join(docs, "---\n"), nil
+}
+
+func (b *YamlBuilder) listFiles(fSys filesys.FileSystem, dirPath string) ([]string, error) {
+	if !fSys.IsDir(dirPath) {
+		return nil, nil
+	}
+	names, err := fSys.ReadDir(dirPath)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	files := make([]string, 0)
+	for _, name := range names {
+		ext := filepath.Ext(name)
+		if ext != ".yaml" && ext != ".yml" {
+			continue
+		}
+		if strings.TrimSuffix(name, ext) == "kustomization" {
+			continue
+		}
+		path := filepath.Join(dirPath, name)
+		if fSys.IsDir(path) || !isManifestFile(fSys, path) {
+			continue
+		}
+		files = append(files, path)
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// isManifestFile returns true if the file consists of Kubernetes objects.
+func isManifestFile(fSys filesys.FileSystem, path string) bool {
+	bs, err := fSys.ReadFile(path)
+	if err != nil {
+		return false
+	}
+	resources, err := parseResources(string(bs))
+	if err != nil || len(resources) == 0 {
+		return false
+	}
+	for _, rn := range resources {
+		if rn.GetApiVersion() == "" || rn.GetKind() == "" {
+			return false
+		}
+	}
+	return true
+}
+
+// copyDirToDisk copies the directory in the file system to the disk.
+func copyDirToDisk(fSys filesys.FileSystem, srcDirPath, dstDirPath string) error {
+	return fSys.Walk(srcDirPath, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		relPath, err := filepath.Rel(srcDirPath, path)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		dstPath := filepath.Join(dstDirPath, relPath)
+		if info.IsDir() {
+			return errors.WithStack(os.MkdirAll(dstPath, 0700))
+		}
+		bs, err := fSys.ReadFile(path)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		return errors.WithStack(ioutil.WriteFile(dstPath, bs, 0600))
+	})
+}
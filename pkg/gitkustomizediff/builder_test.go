@@ -0,0 +1,123 @@
+/*
+Copyright 2021 Daisuke Taniwaki.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gitkustomizediff
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"sigs.k8s.io/kustomize/kyaml/filesys"
+)
+
+func TestYamlBuilder(t *testing.T) {
+	wd, _ := os.Getwd()
+
+	expectedYaml := strings.TrimLeft(`
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: a
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: b
+`, "\n")
+
+	fSys := filesys.MakeFsOnDisk()
+	builder := &YamlBuilder{}
+	rootPath := filepath.Join(wd, "fixtures", "build")
+	fixturesDirPath := filepath.Join(rootPath, "yaml")
+	assert.True(t, builder.Detect(fSys, rootPath, fixturesDirPath))
+	assert.False(t, builder.Detect(fSys, rootPath, filepath.Join(rootPath, "helm", "chart", "templates")))
+	assert.False(t, builder.Detect(fSys, rootPath, filepath.Join(rootPath, "load-restrictions", "overlay")))
+	actualYaml, err := builder.Build(context.Background(), fixturesDirPath, BuildOpts{})
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	assert.Equal(t, expectedYaml, actualYaml)
+
+	memFSys := filesys.MakeFsInMemory()
+	for path, content := range map[string]string{
+		"/repo/.github/workflows/ci.yaml": "on: push\njobs:\n  test:\n    runs-on: ubuntu-latest\n",
+		"/repo/Chart.yaml":                "name: repo\n",
+		"/repo/manifests/cm.yaml":         "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: a\n",
+		"/repo/manifests/values.yaml":     "replicas: 1\n",
+	} {
+		if !assert.NoError(t, memFSys.WriteFile(path, []byte(content))) {
+			t.FailNow()
+		}
+	}
+	// YAML files other than Kubernetes objects are ignored.
+	assert.False(t, builder.Detect(memFSys, "/repo/.github", "/repo/.github/workflows"))
+	// Charts out of the root are ignored.
+	assert.True(t, builder.Detect(memFSys, "/repo/manifests", "/repo/manifests"))
+	assert.False(t, builder.Detect(memFSys, "/repo", "/repo/manifests"))
+	actualYaml, err = builder.Build(context.Background(), "/repo/manifests", BuildOpts{FileSystem: memFSys})
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	assert.Equal(t, "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: a\n", actualYaml)
+}
+
+func TestHelmBuilder(t *testing.T) {
+	wd, _ := os.Getwd()
+
+	tmpDirPath, err := ioutil.TempDir("", "kustomize-diff-test-")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer os.RemoveAll(tmpDirPath)
+	helmPath := filepath.Join(tmpDirPath, "helm")
+	err = ioutil.WriteFile(helmPath, []byte("#!/bin/sh\necho \"$@\"\n"), 0700)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+
+	fSys := filesys.MakeFsOnDisk()
+	builder := &HelmBuilder{}
+	fixturesDirPath := filepath.Join(wd, "fixtures", "build", "helm", "chart")
+	assert.True(t, builder.Detect(fSys, fixturesDirPath, fixturesDirPath))
+	assert.False(t, builder.Detect(fSys, fixturesDirPath, filepath.Join(wd, "fixtures", "build", "yaml")))
+	actual, err := builder.Build(context.Background(), fixturesDirPath, BuildOpts{HelmCommand: helmPath, HelmValuesFile: "values-ci.yaml"})
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	assert.Equal(t, "template chart "+fixturesDirPath+" --values "+filepath.Join(fixturesDirPath, "values-ci.yaml")+"\n", actual)
+
+	_, err = builder.Build(context.Background(), fixturesDirPath, BuildOpts{HelmCommand: helmPath, HelmValuesFile: "values-typo.yaml"})
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "helm values file values-typo.yaml doesn't exist in chart")
+		assert.Equal(t, ErrorCategoryMissingFile, classifyBuildError(err))
+	}
+}
+
+func TestGetBuilder(t *testing.T) {
+	for _, name := range []string{"kustomize", "helm", "yaml"} {
+		builder, err := GetBuilder(name)
+		if assert.NoError(t, err) {
+			assert.Equal(t, name, builder.Name())
+		}
+	}
+	_, err := GetBuilder("unknown")
+	assert.Error(t, err)
+}
@@ -0,0 +1,167 @@
+/*
+Copyright 2021 Daisuke Taniwaki.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gitkustomizediff
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"sort"
+	"strings"
+
+	"github.com/dtaniwaki/git-kustomize-diff/pkg/utils"
+	"github.com/pkg/errors"
+	"sigs.k8s.io/kustomize/api/types"
+	"sigs.k8s.io/kustomize/kyaml/filesys"
+)
+
+const kustomizeModule = "sigs.k8s.io/kustomize/api"
+
+// buildCacheKey returns the hash of the inputs of the kustomization, which are
+// the files in the directories of the kustomization and the resources,
+// components, generators, transformers and validators it refers to
+// recursively, the kustomize version and the build options.
+// It returns false if the build isn't deterministic, e.g. it has remote
+// resources, helm charts or plugins, or it loads files outside the roots.
+func buildCacheKey(ctx context.Context, fSys filesys.FileSystem, dirPath string, opts BuildOpts) (string, bool, error) {
+	if opts.EnableHelm || opts.EnableAlphaPlugins || opts.LoadRestrictions == types.LoadRestrictionsNone {
+		return "", false, nil
+	}
+	h := sha256.New()
+	version, err := kustomizeVersion(ctx, opts)
+	if err != nil {
+		return "", false, err
+	}
+	fmt.Fprintf(h, "version\x00%s\x00", version)
+	fmt.Fprintf(h, "args\x00%s\x00", strings.Join(kustomizeArgs(".", opts), " "))
+
+	files := make(map[string]struct{})
+	visited := make(map[string]bool)
+	queue := []string{filepath.Clean(dirPath)}
+	for len(queue) > 0 {
+		kDir := queue[0]
+		queue = queue[1:]
+		if visited[kDir] {
+			continue
+		}
+		visited[kDir] = true
+		err := fSys.Walk(kDir, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return errors.WithStack(err)
+			}
+			if !info.IsDir() {
+				files[path] = struct{}{}
+			}
+			return nil
+		})
+		if err != nil {
+			return "", false, err
+		}
+		if !utils.KustomizationExistsInFs(fSys, kDir) {
+			continue
+		}
+		k, err := utils.ReadKustomization(fSys, kDir)
+		if err != nil {
+			return "", false, err
+		}
+		// The plugins may be out of the directory as well as the resources.
+		refs := append(utils.KustomizationRefs(k, kDir), utils.KustomizationPluginRefs(k, kDir)...)
+		for _, ref := range refs {
+			if fSys.IsDir(ref) {
+				queue = append(queue, ref)
+			} else if fSys.Exists(ref) {
+				files[ref] = struct{}{}
+			} else {
+				// Remote resources can change anytime.
+				return "", false, nil
+			}
+		}
+	}
+
+	paths := make([]string, 0, len(files))
+	for path := range files {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		relPath, err := filepath.Rel(dirPath, path)
+		if err != nil {
+			return "", false, errors.WithStack(err)
+		}
+		bs, err := fSys.ReadFile(path)
+		if err != nil {
+			return "", false, errors.WithStack(err)
+		}
+		fmt.Fprintf(h, "file\x00%s\x00%d\x00", filepath.ToSlash(relPath), len(bs))
+		h.Write(bs)
+	}
+	return hex.EncodeToString(h.Sum(nil)), true, nil
+}
+
+// kustomizeVersion returns the version of the kustomize binary or the embedded kustomize.
+func kustomizeVersion(ctx context.Context, opts BuildOpts) (string, error) {
+	if opts.KustomizePath != "" {
+		stdout, _, err := (&utils.WorkDir{}).RunCommand(ctx, opts.KustomizePath, "version")
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimSpace(stdout), nil
+	}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, dep := range info.Deps {
+			if dep.Path == kustomizeModule {
+				return fmt.Sprintf("%s %s", kustomizeModule, dep.Version), nil
+			}
+		}
+	}
+	return kustomizeModule, nil
+}
+
+func readBuildCache(cacheDir, key string) (string, bool) {
+	bs, err := ioutil.ReadFile(filepath.Join(cacheDir, key+".yaml"))
+	if err != nil {
+		return "", false
+	}
+	return string(bs), true
+}
+
+func writeBuildCache(cacheDir, key, yaml string) error {
+	err := os.MkdirAll(cacheDir, 0700)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	f, err := ioutil.TempFile(cacheDir, key+"-*.tmp")
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer os.Remove(f.Name())
+	_, err = f.WriteString(yaml)
+	if err != nil {
+		f.Close()
+		return errors.WithStack(err)
+	}
+	err = f.Close()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	return errors.WithStack(os.Rename(f.Name(), filepath.Join(cacheDir, key+".yaml")))
+}
@@ -0,0 +1,166 @@
+/*
+Copyright 2021 Daisuke Taniwaki.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gitkustomizediff
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"sigs.k8s.io/kustomize/api/types"
+	"sigs.k8s.io/kustomize/kyaml/filesys"
+)
+
+func makeCacheFixture(t *testing.T, root, image string) filesys.FileSystem {
+	fSys := filesys.MakeFsInMemory()
+	files := map[string]string{
+		"base/kustomization.yaml":    "resources:\n- pod.yaml\n",
+		"base/pod.yaml":              "apiVersion: v1\nkind: Pod\nmetadata:\n  name: foo\nspec:\n  containers:\n  - name: foo\n    image: " + image + "\n",
+		"overlay/kustomization.yaml": "resources:\n- ../base\nnamePrefix: prod-\n",
+	}
+	for path, content := range files {
+		err := fSys.WriteFile(filepath.Join(root, path), []byte(content))
+		if !assert.NoError(t, err) {
+			t.FailNow()
+		}
+	}
+	return fSys
+}
+
+func TestBuildCacheKey(t *testing.T) {
+	fSys := makeCacheFixture(t, "/a", "nginx:1.20")
+	key, ok, err := buildCacheKey(context.Background(), fSys, "/a/overlay", BuildOpts{})
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	assert.True(t, ok)
+
+	// The key doesn't depend on the location.
+	otherKey, _, err := buildCacheKey(context.Background(), makeCacheFixture(t, "/b/c", "nginx:1.20"), "/b/c/overlay", BuildOpts{})
+	if assert.NoError(t, err) {
+		assert.Equal(t, key, otherKey)
+	}
+	// The key depends on the referred files.
+	otherKey, _, err = buildCacheKey(context.Background(), makeCacheFixture(t, "/a", "nginx:1.21"), "/a/overlay", BuildOpts{})
+	if assert.NoError(t, err) {
+		assert.NotEqual(t, key, otherKey)
+	}
+	// The key depends on the options.
+	otherKey, _, err = buildCacheKey(context.Background(), fSys, "/a/overlay", BuildOpts{LoadRestrictions: types.LoadRestrictionsRootOnly})
+	if assert.NoError(t, err) {
+		assert.NotEqual(t, key, otherKey)
+	}
+
+	_, ok, err = buildCacheKey(context.Background(), fSys, "/a/overlay", BuildOpts{EnableHelm: true})
+	if assert.NoError(t, err) {
+		assert.False(t, ok)
+	}
+	err = fSys.WriteFile("/a/remote/kustomization.yaml", []byte("resources:\n- github.com/example/repo?ref=v1\n"))
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	_, ok, err = buildCacheKey(context.Background(), fSys, "/a/remote", BuildOpts{})
+	if assert.NoError(t, err) {
+		assert.False(t, ok)
+	}
+}
+
+func TestBuildCache(t *testing.T) {
+	cacheDir, err := ioutil.TempDir("", "kustomize-diff-cache-test-")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer os.RemoveAll(cacheDir)
+
+	fSys := makeCacheFixture(t, "/a", "nginx:1.20")
+	opts := BuildOpts{FileSystem: fSys, CacheDir: cacheDir}
+	yaml, err := Build(context.Background(), "/a/overlay", opts)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	assert.Contains(t, yaml, "name: prod-foo")
+
+	key, _, err := buildCacheKey(context.Background(), fSys, "/a/overlay", opts)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	cacheFile := filepath.Join(cacheDir, key+".yaml")
+	bs, err := ioutil.ReadFile(cacheFile)
+	if assert.NoError(t, err) {
+		assert.Equal(t, yaml, string(bs))
+	}
+
+	// The cached build is returned on a hit.
+	err = ioutil.WriteFile(cacheFile, []byte("cached"), 0600)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	yaml, err = Build(context.Background(), "/a/overlay", opts)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "cached", yaml)
+	}
+
+	// Failed builds aren't cached.
+	err = fSys.WriteFile("/a/invalid/kustomization.yaml", []byte("resources:\n- missing.yaml\n"))
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	_, err = Build(context.Background(), "/a/invalid", opts)
+	assert.Error(t, err)
+	files, err := ioutil.ReadDir(cacheDir)
+	if assert.NoError(t, err) {
+		assert.Len(t, files, 1)
+	}
+}
+
+func TestBuildCacheTransformers(t *testing.T) {
+	cacheDir, err := ioutil.TempDir("", "kustomize-diff-cache-test-")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer os.RemoveAll(cacheDir)
+
+	fSys := makeCacheFixture(t, "/a", "nginx:1.20")
+	prefix := "apiVersion: builtin\nkind: PrefixSuffixTransformer\nmetadata:\n  name: prefix\nprefix: %s-\nfieldSpecs:\n- path: metadata/name\n"
+	for path, content := range map[string]string{
+		"/a/app/kustomization.yaml":          "resources:\n- ../base\ntransformers:\n- ../transformers\n",
+		"/a/transformers/kustomization.yaml": "resources:\n- prefix.yaml\n",
+		"/a/transformers/prefix.yaml":        fmt.Sprintf(prefix, "v1"),
+	} {
+		if !assert.NoError(t, fSys.WriteFile(path, []byte(content))) {
+			t.FailNow()
+		}
+	}
+	opts := BuildOpts{FileSystem: fSys, CacheDir: cacheDir}
+	yaml, err := Build(context.Background(), "/a/app", opts)
+	if assert.NoError(t, err) {
+		assert.Contains(t, yaml, "name: v1-foo")
+	}
+
+	// The change of the transformer out of the directory misses the cache.
+	if !assert.NoError(t, fSys.WriteFile("/a/transformers/prefix.yaml", []byte(fmt.Sprintf(prefix, "v2")))) {
+		t.FailNow()
+	}
+	yaml, err = Build(context.Background(), "/a/app", opts)
+	if assert.NoError(t, err) {
+		assert.Contains(t, yaml, "name: v2-foo")
+	}
+}
@@ -0,0 +1,153 @@
+/*
+Copyright 2021 Daisuke Taniwaki.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gitkustomizediff
+
+import (
+	"strings"
+)
+
+// CIProvider is a CI system detected from the environment variables.
+type CIProvider string
+
+const (
+	CIProviderGitHubActions CIProvider = "github-actions"
+	CIProviderGitLabCI      CIProvider = "gitlab-ci"
+	CIProviderJenkins       CIProvider = "jenkins"
+	CIProviderBuildkite     CIProvider = "buildkite"
+)
+
+// CIOutput is where the report is written in addition to the stdout.
+type CIOutput string
+
+const (
+	// CIOutputStdout writes the report only to the stdout.
+	CIOutputStdout CIOutput = "stdout"
+	// CIOutputFile appends the report to a file, e.g. the job summary of GitHub Actions.
+	CIOutputFile CIOutput = "file"
+	// CIOutputBuildkiteAnnotation annotates the Buildkite build with the report.
+	CIOutputBuildkiteAnnotation CIOutput = "buildkite-annotation"
+)
+
+// CIEnv is the base, the target and the output detected in a CI job.
+type CIEnv struct {
+	Provider CIProvider
+	// Base is empty if the CI system doesn't tell the base of the job.
+	Base       string
+	Target     string
+	Output     CIOutput
+	OutputFile string
+}
+
+// zeroCommit is the commit hash CI systems set for a missing commit, e.g. the
+// previous commit of a new branch.
+const zeroCommit = "0000000000000000000000000000000000000000"
+
+// DetectCI detects the CI system from the environment variables and returns
+// nil if none is detected. Pull requests are diffed against their base
+// branches and the other jobs against the previous commits if known.
+func DetectCI(env map[string]string) *CIEnv {
+	switch {
+	case env["GITHUB_ACTIONS"] == "true":
+		return detectGitHubActions(env)
+	case env["GITLAB_CI"] == "true":
+		return detectGitLabCI(env)
+	case env["BUILDKITE"] == "true":
+		return detectBuildkite(env)
+	case env["JENKINS_URL"] != "":
+		return detectJenkins(env)
+	}
+	return nil
+}
+
+func detectGitHubActions(env map[string]string) *CIEnv {
+	ci := &CIEnv{Provider: CIProviderGitHubActions, Target: orHEAD(env["GITHUB_SHA"]), Output: CIOutputStdout}
+	switch {
+	case env["GITHUB_EVENT_NAME"] == "pull_request" && env["GITHUB_SHA"] != "":
+		// GITHUB_SHA is the merge ref of the pull request, whose first parent
+		// is the base branch.
+		ci.Base = env["GITHUB_SHA"] + "^1"
+	case env["GITHUB_BASE_REF"] != "":
+		ci.Base = "origin/" + env["GITHUB_BASE_REF"]
+		ci.Target = "HEAD"
+	}
+	if env["GITHUB_STEP_SUMMARY"] != "" {
+		ci.Output = CIOutputFile
+		ci.OutputFile = env["GITHUB_STEP_SUMMARY"]
+	}
+	return ci
+}
+
+func detectGitLabCI(env map[string]string) *CIEnv {
+	ci := &CIEnv{Provider: CIProviderGitLabCI, Target: orHEAD(env["CI_COMMIT_SHA"]), Output: CIOutputStdout}
+	switch {
+	case env["CI_MERGE_REQUEST_EVENT_TYPE"] == "merged_result" && env["CI_MERGE_REQUEST_TARGET_BRANCH_SHA"] != "":
+		// The commit is the merge of the merge request into the target branch.
+		ci.Base = env["CI_MERGE_REQUEST_TARGET_BRANCH_SHA"]
+	case env["CI_MERGE_REQUEST_DIFF_BASE_SHA"] != "":
+		ci.Base = env["CI_MERGE_REQUEST_DIFF_BASE_SHA"]
+	case env["CI_MERGE_REQUEST_TARGET_BRANCH_NAME"] != "":
+		ci.Base = "origin/" + env["CI_MERGE_REQUEST_TARGET_BRANCH_NAME"]
+	default:
+		ci.Base = previousCommit(env["CI_COMMIT_BEFORE_SHA"])
+	}
+	return ci
+}
+
+func detectBuildkite(env map[string]string) *CIEnv {
+	ci := &CIEnv{Provider: CIProviderBuildkite, Target: orHEAD(env["BUILDKITE_COMMIT"]), Output: CIOutputBuildkiteAnnotation}
+	if pr := env["BUILDKITE_PULL_REQUEST"]; pr != "" && pr != "false" && env["BUILDKITE_PULL_REQUEST_BASE_BRANCH"] != "" {
+		ci.Base = "origin/" + env["BUILDKITE_PULL_REQUEST_BASE_BRANCH"]
+	}
+	return ci
+}
+
+func detectJenkins(env map[string]string) *CIEnv {
+	ci := &CIEnv{Provider: CIProviderJenkins, Target: orHEAD(env["GIT_COMMIT"]), Output: CIOutputStdout}
+	if env["CHANGE_ID"] != "" && env["CHANGE_TARGET"] != "" {
+		ci.Base = "origin/" + env["CHANGE_TARGET"]
+	} else {
+		ci.Base = previousCommit(env["GIT_PREVIOUS_SUCCESSFUL_COMMIT"])
+	}
+	return ci
+}
+
+// orHEAD returns HEAD for an empty commit because the CI systems check out
+// detached HEADs, which have no current branch.
+func orHEAD(commit string) string {
+	if commit == "" {
+		return "HEAD"
+	}
+	return commit
+}
+
+func previousCommit(commit string) string {
+	if commit == zeroCommit {
+		return ""
+	}
+	return commit
+}
+
+// EnvMap converts the environment in the form of os.Environ into a map.
+func EnvMap(environ []string) map[string]string {
+	env := make(map[string]string, len(environ))
+	for _, kv := range environ {
+		if i := strings.Index(kv, "="); i >= 0 {
+			env[kv[:i]] = kv[i+1:]
+		}
+	}
+	return env
+}
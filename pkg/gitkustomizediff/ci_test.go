@@ -0,0 +1,92 @@
+/*
+Copyright 2021 Daisuke Taniwaki.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gitkustomizediff
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDetectCI(t *testing.T) {
+	assert.Nil(t, DetectCI(map[string]string{"PATH": "/usr/bin"}))
+
+	assert.Equal(t, &CIEnv{Provider: CIProviderGitHubActions, Base: "abc^1", Target: "abc", Output: CIOutputFile, OutputFile: "/tmp/summary.md"}, DetectCI(map[string]string{
+		"GITHUB_ACTIONS":      "true",
+		"GITHUB_EVENT_NAME":   "pull_request",
+		"GITHUB_BASE_REF":     "main",
+		"GITHUB_SHA":          "abc",
+		"GITHUB_STEP_SUMMARY": "/tmp/summary.md",
+	}))
+	assert.Equal(t, &CIEnv{Provider: CIProviderGitHubActions, Base: "origin/main", Target: "HEAD", Output: CIOutputStdout}, DetectCI(map[string]string{
+		"GITHUB_ACTIONS":    "true",
+		"GITHUB_EVENT_NAME": "pull_request_target",
+		"GITHUB_BASE_REF":   "main",
+		"GITHUB_SHA":        "abc",
+	}))
+	assert.Equal(t, &CIEnv{Provider: CIProviderGitHubActions, Target: "abc", Output: CIOutputStdout}, DetectCI(map[string]string{
+		"GITHUB_ACTIONS":    "true",
+		"GITHUB_EVENT_NAME": "push",
+		"GITHUB_SHA":        "abc",
+	}))
+
+	assert.Equal(t, &CIEnv{Provider: CIProviderGitLabCI, Base: "def", Target: "abc", Output: CIOutputStdout}, DetectCI(map[string]string{
+		"GITLAB_CI":                           "true",
+		"CI_COMMIT_SHA":                       "abc",
+		"CI_MERGE_REQUEST_DIFF_BASE_SHA":      "def",
+		"CI_MERGE_REQUEST_TARGET_BRANCH_NAME": "main",
+	}))
+	assert.Equal(t, &CIEnv{Provider: CIProviderGitLabCI, Base: "ghi", Target: "abc", Output: CIOutputStdout}, DetectCI(map[string]string{
+		"GITLAB_CI":                          "true",
+		"CI_COMMIT_SHA":                      "abc",
+		"CI_MERGE_REQUEST_EVENT_TYPE":        "merged_result",
+		"CI_MERGE_REQUEST_DIFF_BASE_SHA":     "def",
+		"CI_MERGE_REQUEST_TARGET_BRANCH_SHA": "ghi",
+	}))
+	assert.Equal(t, &CIEnv{Provider: CIProviderGitLabCI, Target: "abc", Output: CIOutputStdout}, DetectCI(map[string]string{
+		"GITLAB_CI":            "true",
+		"CI_COMMIT_SHA":        "abc",
+		"CI_COMMIT_BEFORE_SHA": zeroCommit,
+	}))
+
+	assert.Equal(t, &CIEnv{Provider: CIProviderBuildkite, Base: "origin/main", Target: "abc", Output: CIOutputBuildkiteAnnotation}, DetectCI(map[string]string{
+		"BUILDKITE":                          "true",
+		"BUILDKITE_COMMIT":                   "abc",
+		"BUILDKITE_PULL_REQUEST":             "1",
+		"BUILDKITE_PULL_REQUEST_BASE_BRANCH": "main",
+	}))
+	assert.Equal(t, &CIEnv{Provider: CIProviderBuildkite, Target: "abc", Output: CIOutputBuildkiteAnnotation}, DetectCI(map[string]string{
+		"BUILDKITE":              "true",
+		"BUILDKITE_COMMIT":       "abc",
+		"BUILDKITE_PULL_REQUEST": "false",
+	}))
+
+	assert.Equal(t, &CIEnv{Provider: CIProviderJenkins, Base: "origin/main", Target: "abc", Output: CIOutputStdout}, DetectCI(map[string]string{
+		"JENKINS_URL":   "https://jenkins.example.com/",
+		"GIT_COMMIT":    "abc",
+		"CHANGE_ID":     "1",
+		"CHANGE_TARGET": "main",
+	}))
+	assert.Equal(t, &CIEnv{Provider: CIProviderJenkins, Base: "def", Target: "HEAD", Output: CIOutputStdout}, DetectCI(map[string]string{
+		"JENKINS_URL":                    "https://jenkins.example.com/",
+		"GIT_PREVIOUS_SUCCESSFUL_COMMIT": "def",
+	}))
+}
+
+func TestEnvMap(t *testing.T) {
+	assert.Equal(t, map[string]string{"A": "1", "B": "x=y", "C": ""}, EnvMap([]string{"A=1", "B=x=y", "C=", "D"}))
+}
@@ -17,97 +17,487 @@ limitations under the License.
 package gitkustomizediff
 
 import (
+	"context"
+	"fmt"
 	"path/filepath"
 	"regexp"
+	"sort"
+	"strings"
+	"time"
 
 	"github.com/dtaniwaki/git-kustomize-diff/pkg/utils"
 	"github.com/pkg/errors"
-	log "github.com/sirupsen/logrus"
 	"sigs.k8s.io/kustomize/api/krusty"
+	"sigs.k8s.io/kustomize/api/types"
 	"sigs.k8s.io/kustomize/kyaml/filesys"
 )
 
 type DiffOpts struct {
-	IncludeRegexp *regexp.Regexp
-	ExcludeRegexp *regexp.Regexp
-	KustomizePath string
+	// Dirs are the sub directories to look for the targets in, e.g. the
+	// directories given to Run relative to the repository root. The results
+	// are keyed by the paths relative to the base and target directories.
+	// Defaults to the whole directories.
+	Dirs               []string
+	IncludeRegexp      *regexp.Regexp
+	ExcludeRegexp      *regexp.Regexp
+	LeafOnly           bool
+	KustomizePath      string
+	LoadRestrictions   types.LoadRestrictions
+	EnableHelm         bool
+	HelmCommand        string
+	EnableAlphaPlugins bool
+	EnableExec         bool
+	HelmValuesFile     string
+	// Builders to detect and build the target directories in order of precedence.
+	// Defaults to DefaultBuilders.
+	Builders         []Builder
+	BaseFileSystem   filesys.FileSystem
+	TargetFileSystem filesys.FileSystem
+	// Debug shows the full details of the errors.
+	Debug bool
+	// Validator validates the target builds if set.
+	Validator *Validator
+	// PolicyChecker checks the target builds of the changed directories if set.
+	PolicyChecker *PolicyChecker
+	// RiskyKinds are the kinds whose deletions are reported as risks.
+	// Defaults to DefaultRiskyKinds.
+	RiskyKinds []string
+	// ImmutableFields are the fields whose changes require to recreate the resources.
+	// Defaults to DefaultImmutableFields.
+	ImmutableFields []ImmutableField
+	// CacheDir is a directory to cache the builds. Disabled if empty.
+	CacheDir string
+	// RemoteResources defaults to RemoteResourcesFetch.
+	RemoteResources RemoteResourcesMode
+	// RemoteMirrorDir is the mirror of the remote resources for RemoteResourcesMirror.
+	RemoteMirrorDir string
+	// GitPath is the git binary to fetch the remote resources into the mirror.
+	// Defaults to git.
+	GitPath string
+	// BuildTimeout limits the time of each build. Disabled if zero. The builds
+	// of the embedded kustomize timed out are left running in the background.
+	BuildTimeout time.Duration
+	// Logger defaults to discard the logs.
+	Logger Logger
+	// Progress receives the progress events if set.
+	Progress ProgressFunc
 }
 
-func Diff(baseDirPath, targetDirPath string, opts DiffOpts) (*DiffMap, error) {
-	log.Info("Start diff")
-	listOpts := utils.ListKustomizeDirsOpts{
-		IncludeRegexp: opts.IncludeRegexp,
-		ExcludeRegexp: opts.ExcludeRegexp,
+func (opts DiffOpts) buildOpts(fSys filesys.FileSystem) BuildOpts {
+	return BuildOpts{
+		KustomizePath:      opts.KustomizePath,
+		LoadRestrictions:   opts.LoadRestrictions,
+		EnableHelm:         opts.EnableHelm,
+		HelmCommand:        opts.HelmCommand,
+		EnableAlphaPlugins: opts.EnableAlphaPlugins,
+		EnableExec:         opts.EnableExec,
+		HelmValuesFile:     opts.HelmValuesFile,
+		FileSystem:         fSys,
+		CacheDir:           opts.CacheDir,
+		RemoteResources:    opts.RemoteResources,
+		RemoteMirrorDir:    opts.RemoteMirrorDir,
+		GitPath:            opts.GitPath,
+		Logger:             opts.Logger,
 	}
-	baseKDirs, err := utils.ListKustomizeDirs(baseDirPath, listOpts)
+}
+
+// Diff builds and diffs the directories. Builds timed out or canceled by the
+// context are reported as the results of the directories.
+func Diff(ctx context.Context, baseDirPath, targetDirPath string, opts DiffOpts) (*DiffMap, error) {
+	logger := loggerOrNop(opts.Logger)
+	logger.Infof("Start diff")
+	d := newDiffer(opts)
+	baseFSys := d.baseFSys
+	targetFSys := d.targetFSys
+	d.baseRoot, d.targetRoot = baseDirPath, targetDirPath
+	baseKDirs, err := listKustomizeDirs(baseFSys, baseDirPath, opts.Dirs, d.listOpts(baseDirPath))
 	if err != nil {
 		return nil, err
 	}
-	log.Debugf("base dirs: %+v", baseKDirs)
-	targetKDirs, err := utils.ListKustomizeDirs(targetDirPath, listOpts)
+	logger.Debugf("base dirs: %+v", baseKDirs)
+	targetKDirs, err := listKustomizeDirs(targetFSys, targetDirPath, opts.Dirs, d.listOpts(targetDirPath))
 	if err != nil {
 		return nil, err
 	}
-	log.Debugf("target dirs: %+v", targetKDirs)
-	kDirs := map[string]struct{}{}
+	logger.Debugf("target dirs: %+v", targetKDirs)
+	kDirSet := map[string]struct{}{}
 	for _, kDir := range append(baseKDirs, targetKDirs...) {
-		kDirs[kDir] = struct{}{}
-	}
-	diffMap := NewDiffMap()
-	for kDir := range kDirs {
-		baseKDirPath := filepath.Join(baseDirPath, kDir)
-		if !utils.KustomizationExists(baseKDirPath) {
-			err := utils.MakeKustomizeDir(baseKDirPath)
-			if err != nil {
-				diffMap.Results[kDir] = &DiffError{err}
-				continue
-			}
-		}
-		targetKDirPath := filepath.Join(targetDirPath, kDir)
-		if !utils.KustomizationExists(targetKDirPath) {
-			err := utils.MakeKustomizeDir(targetKDirPath)
-			if err != nil {
-				diffMap.Results[kDir] = &DiffError{err}
-				continue
-			}
-		}
-		baseYaml, err := Build(baseKDirPath, BuildOpts{opts.KustomizePath})
-		if err != nil {
-			diffMap.Results[kDir] = &DiffError{err}
+		kDirSet[kDir] = struct{}{}
+	}
+	kDirs := make([]string, 0, len(kDirSet))
+	for kDir := range kDirSet {
+		kDirs = append(kDirs, kDir)
+	}
+	sort.Strings(kDirs)
+	d.diffMap.Warnings = append(d.diffMap.Warnings, ambiguousKustomizationWarnings(logger, "base", baseFSys, baseDirPath, baseKDirs)...)
+	d.diffMap.Warnings = append(d.diffMap.Warnings, ambiguousKustomizationWarnings(logger, "target", targetFSys, targetDirPath, targetKDirs)...)
+	pairs := make([]DirPair, 0, len(kDirs))
+	for _, kDir := range kDirs {
+		pairs = append(pairs, DirPair{Base: filepath.Join(baseDirPath, kDir), Target: filepath.Join(targetDirPath, kDir)})
+	}
+	d.diffAll(ctx, kDirs, pairs)
+	return d.diffMap, nil
+}
+
+// listOpts returns the options to list the targets detected by the builders
+// in the root directory.
+func (d *differ) listOpts(rootPath string) utils.ListKustomizeDirsOpts {
+	return utils.ListKustomizeDirsOpts{
+		IncludeRegexp: d.opts.IncludeRegexp,
+		ExcludeRegexp: d.opts.ExcludeRegexp,
+		Detect: func(fSys filesys.FileSystem, path string) bool {
+			return detectBuilder(d.builders, fSys, rootPath, path) != nil
+		},
+		LeafOnly: d.opts.LeafOnly,
+	}
+}
+
+// listKustomizeDirs lists the targets in the sub directories, which default to
+// the directory itself, and returns them relative to the directory.
+func listKustomizeDirs(fSys filesys.FileSystem, dirPath string, subDirs []string, opts utils.ListKustomizeDirsOpts) ([]string, error) {
+	if len(subDirs) == 0 {
+		subDirs = []string{"."}
+	}
+	opts.FileSystem = fSys
+	kDirSet := make(map[string]struct{})
+	for _, subDir := range subDirs {
+		subDirPath := filepath.Join(dirPath, subDir)
+		if !fSys.IsDir(subDirPath) {
+			// The directory may exist only on the other side.
 			continue
 		}
-		targetYaml, err := Build(targetKDirPath, BuildOpts{opts.KustomizePath})
+		kDirs, err := utils.ListKustomizeDirs(subDirPath, opts)
 		if err != nil {
-			diffMap.Results[kDir] = &DiffError{err}
-			continue
+			return nil, err
 		}
+		for _, kDir := range kDirs {
+			kDirSet[filepath.Join(subDir, kDir)] = struct{}{}
+		}
+	}
+	kDirs := make([]string, 0, len(kDirSet))
+	for kDir := range kDirSet {
+		kDirs = append(kDirs, kDir)
+	}
+	sort.Strings(kDirs)
+	return kDirs, nil
+}
 
-		content, err := utils.Diff(baseYaml, targetYaml)
+// differ builds and diffs pairs of directories into a diff map.
+type differ struct {
+	opts            DiffOpts
+	logger          Logger
+	builders        []Builder
+	baseFSys        filesys.FileSystem
+	targetFSys      filesys.FileSystem
+	riskyKinds      []string
+	immutableFields []ImmutableField
+	diffMap         *DiffMap
+	// envPairs are the pairs of the directories by the keys if the pairs are
+	// environments rather than changes, whose risks aren't reported.
+	envPairs map[string]DirPair
+	// baseRoot and targetRoot are the root directories of the listings.
+	baseRoot   string
+	targetRoot string
+}
+
+func newDiffer(opts DiffOpts) *differ {
+	d := &differ{
+		opts:            opts,
+		logger:          loggerOrNop(opts.Logger),
+		builders:        opts.Builders,
+		baseFSys:        opts.BaseFileSystem,
+		targetFSys:      opts.TargetFileSystem,
+		riskyKinds:      opts.RiskyKinds,
+		immutableFields: opts.ImmutableFields,
+		diffMap:         NewDiffMap(),
+	}
+	if d.baseFSys == nil {
+		d.baseFSys = filesys.MakeFsOnDisk()
+	}
+	if d.targetFSys == nil {
+		d.targetFSys = filesys.MakeFsOnDisk()
+	}
+	if len(d.builders) == 0 {
+		d.builders = DefaultBuilders
+	}
+	if d.riskyKinds == nil {
+		d.riskyKinds = DefaultRiskyKinds
+	}
+	if d.immutableFields == nil {
+		d.immutableFields = DefaultImmutableFields
+	}
+	return d
+}
+
+// diffAll diffs the pairs and stores the results with the keys.
+func (d *differ) diffAll(ctx context.Context, keys []string, pairs []DirPair) {
+	for i, pair := range pairs {
+		start := time.Now()
+		d.diff(ctx, keys[i], pair, i, len(pairs))
+		d.opts.Progress.emit(ProgressEvent{Type: ProgressEventDiffComputed, Dir: keys[i], Done: i + 1, Total: len(pairs), Duration: time.Since(start)})
+	}
+}
+
+func (d *differ) diff(ctx context.Context, key string, pair DirPair, done, total int) {
+	opts := d.opts
+	opts.Progress.emit(ProgressEvent{Type: ProgressEventBuildStarted, Dir: key, Done: done, Total: total})
+	timing := KustomizationTiming{Dir: key}
+	defer func() {
+		d.diffMap.Timings[key] = timing
+	}()
+	start := time.Now()
+	baseYaml, baseErr := buildWith(ctx, opts.BuildTimeout, d.builders, d.baseFSys, d.baseRoot, pair.Base, opts.buildOpts(opts.BaseFileSystem))
+	timing.BaseBuild = time.Since(start)
+	targetYaml, targetErr := buildWith(ctx, opts.BuildTimeout, d.builders, d.targetFSys, d.targetRoot, pair.Target, opts.buildOpts(opts.TargetFileSystem))
+	timing.TargetBuild = time.Since(start) - timing.BaseBuild
+	opts.Progress.emit(ProgressEvent{Type: ProgressEventBuildFinished, Dir: key, Done: done, Total: total, Duration: time.Since(start)})
+	d.diffMap.BaseBuilds[key] = &BuildResult{Yaml: baseYaml, Err: baseErr}
+	d.diffMap.TargetBuilds[key] = &BuildResult{Yaml: targetYaml, Err: targetErr}
+	if opts.Validator != nil && targetErr == nil {
+		validationErrors, err := opts.Validator.Validate(targetYaml)
 		if err != nil {
-			diffMap.Results[kDir] = &DiffError{err}
+			validationErrors = []string{ConciseErrorMessage(err)}
+		}
+		if len(validationErrors) > 0 {
+			d.diffMap.Validations[key] = validationErrors
+		}
+	}
+	if opts.PolicyChecker != nil && targetErr == nil && (baseErr != nil || baseYaml != targetYaml) {
+		violations, err := opts.PolicyChecker.Check(targetYaml)
+		if err != nil {
+			violations = []PolicyViolation{{Policy: "-", Resource: "-", Message: ConciseErrorMessage(err)}}
+		}
+		if len(violations) > 0 {
+			d.diffMap.Violations[key] = violations
+		}
+	}
+	if baseErr != nil || targetErr != nil {
+		if envPair, ok := d.envPairs[key]; ok {
+			d.diffMap.Results[key] = newEnvBuildError(envPair, baseErr, targetErr, opts.Debug)
+		} else {
+			d.diffMap.Results[key] = newBuildError(baseErr, targetErr, opts.Debug)
+		}
+		return
+	}
+
+	if d.envPairs == nil {
+		d.classifyRisks(key, baseYaml, targetYaml)
+	}
+	imageChanges, err := ImageChanges(baseYaml, targetYaml)
+	if err != nil {
+		d.logger.Debugf("Skip extracting the image changes of %s: %v", key, err)
+	} else if len(imageChanges) > 0 {
+		d.diffMap.ImageChanges[key] = imageChanges
+	}
+
+	diffStart := time.Now()
+	content, err := utils.Diff(ctx, baseYaml, targetYaml)
+	timing.Diff = time.Since(diffStart)
+	if err != nil {
+		d.diffMap.Results[key] = newDiffError(err, opts.Debug)
+		return
+	}
+	d.diffMap.Results[key] = &DiffContent{content}
+}
+
+// classifyRisks records the risks and the immutable field changes of the change.
+// The selector changes reported as risks aren't reported again as immutable
+// field changes.
+func (d *differ) classifyRisks(key, baseYaml, targetYaml string) {
+	risks, err := ClassifyRisks(baseYaml, targetYaml, d.riskyKinds)
+	if err != nil {
+		d.logger.Debugf("Skip classifying the risks of %s: %v", key, err)
+	} else if len(risks) > 0 {
+		d.diffMap.Risks[key] = risks
+	}
+	immutableFieldChanges, err := CheckImmutableFields(baseYaml, targetYaml, d.immutableFields)
+	if err != nil {
+		d.logger.Debugf("Skip checking the immutable fields of %s: %v", key, err)
+	} else {
+		immutableFieldChanges = withoutSelectorRisks(immutableFieldChanges, risks)
+		if len(immutableFieldChanges) > 0 {
+			d.diffMap.ImmutableFieldChanges[key] = immutableFieldChanges
+		}
+	}
+}
+
+// withoutSelectorRisks drops the immutable field changes of the selectors
+// already reported as the risks.
+func withoutSelectorRisks(changes []ImmutableFieldChange, risks []Risk) []ImmutableFieldChange {
+	selectorRisks := make(map[string]bool)
+	for _, risk := range risks {
+		if risk.Category == RiskCategorySelectorChange {
+			selectorRisks[risk.Resource] = true
+		}
+	}
+	filtered := make([]ImmutableFieldChange, 0, len(changes))
+	for _, change := range changes {
+		if change.Field == "spec.selector" && selectorRisks[change.Resource] {
 			continue
 		}
-		diffMap.Results[kDir] = &DiffContent{content}
+		filtered = append(filtered, change)
+	}
+	return filtered
+}
+
+// ambiguousKustomizationWarnings warns directories with multiple kustomization files.
+func ambiguousKustomizationWarnings(logger Logger, side string, fSys filesys.FileSystem, dirPath string, kDirs []string) []string {
+	warnings := make([]string, 0)
+	for _, kDir := range kDirs {
+		files := utils.KustomizationFiles(fSys, filepath.Join(dirPath, kDir))
+		if len(files) > 1 {
+			warning := fmt.Sprintf("%s has multiple kustomization files in %s: %s", kDir, side, strings.Join(files, ", "))
+			logger.Warnf("%s", warning)
+			warnings = append(warnings, warning)
+		}
 	}
-	return diffMap, nil
+	return warnings
+}
+
+// buildWith builds the directory with the first builder detecting it.
+// A directory which no builder detects renders nothing.
+func buildWith(ctx context.Context, timeout time.Duration, builders []Builder, fSys filesys.FileSystem, rootPath, dirPath string, opts BuildOpts) (string, error) {
+	builder := detectBuilder(builders, fSys, rootPath, dirPath)
+	if builder == nil {
+		return "", nil
+	}
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	loggerOrNop(opts.Logger).Debugf("Build %s with %s", dirPath, builder.Name())
+	return builder.Build(ctx, dirPath, opts)
 }
 
 type BuildOpts struct {
 	KustomizePath string
+	// LoadRestrictions defaults to LoadRestrictionsRootOnly.
+	LoadRestrictions types.LoadRestrictions
+	EnableHelm       bool
+	// HelmCommand defaults to helm.
+	HelmCommand        string
+	EnableAlphaPlugins bool
+	// EnableExec requires EnableAlphaPlugins.
+	EnableExec bool
+	// HelmValuesFile is a values file relative to the chart directory used by
+	// HelmBuilder. The build fails if a chart doesn't have it.
+	HelmValuesFile string
+	// FileSystem to read the kustomization from. Defaults to the disk.
+	FileSystem filesys.FileSystem
+	// CacheDir is a directory to cache the builds keyed by the inputs.
+	CacheDir string
+	// RemoteResources defaults to RemoteResourcesFetch.
+	RemoteResources RemoteResourcesMode
+	// RemoteMirrorDir is a directory of the remote resources keyed by the URL
+	// and the ref, which is used by RemoteResourcesMirror.
+	RemoteMirrorDir string
+	// GitPath is the git binary to fetch the remote resources into the mirror.
+	// Defaults to git.
+	GitPath string
+	// Logger defaults to discard the logs.
+	Logger Logger
 }
 
-func Build(dirPath string, opts BuildOpts) (string, error) {
+// Build builds the kustomization. The successful builds are cached in
+// CacheDir if set. The embedded kustomize can't be canceled, so it's left
+// running in a goroutine when the context is done, which fails to access the
+// file system after Build returns.
+func Build(ctx context.Context, dirPath string, opts BuildOpts) (string, error) {
+	if opts.EnableExec && !opts.EnableAlphaPlugins {
+		return "", errors.New("exec function plugins require the alpha plugins to be enabled")
+	}
+	if opts.CacheDir == "" {
+		return build(ctx, dirPath, opts)
+	}
+	logger := loggerOrNop(opts.Logger)
+	fSys := opts.FileSystem
+	if fSys == nil {
+		fSys = filesys.MakeFsOnDisk()
+	}
+	key, ok, err := buildCacheKey(ctx, fSys, dirPath, opts)
+	if err != nil {
+		// Leave the error to the build.
+		logger.Debugf("Skip caching the build of %s: %v", dirPath, err)
+		return build(ctx, dirPath, opts)
+	}
+	if !ok {
+		logger.Debugf("Skip caching the non-deterministic build of %s", dirPath)
+		return build(ctx, dirPath, opts)
+	}
+	if yaml, hit := readBuildCache(opts.CacheDir, key); hit {
+		logger.Debugf("Use the cached build of %s", dirPath)
+		return yaml, nil
+	}
+	yaml, err := build(ctx, dirPath, opts)
+	if err != nil {
+		return "", err
+	}
+	err = writeBuildCache(opts.CacheDir, key, yaml)
+	if err != nil {
+		logger.Warnf("Failed to cache the build of %s: %v", dirPath, err)
+	}
+	return yaml, nil
+}
+
+func build(ctx context.Context, dirPath string, opts BuildOpts) (string, error) {
+	fSys := opts.FileSystem
+	if fSys == nil {
+		fSys = filesys.MakeFsOnDisk()
+	}
+	switch opts.RemoteResources {
+	case RemoteResourcesForbid:
+		err := checkRemoteResources(fSys, dirPath)
+		if err != nil {
+			return "", err
+		}
+	case RemoteResourcesMirror:
+		if opts.KustomizePath != "" {
+			return "", errors.New("remote resources can't be mirrored for a kustomize binary")
+		}
+		var err error
+		fSys, err = mirrorRemoteResources(ctx, fSys, dirPath, opts)
+		if err != nil {
+			return "", err
+		}
+	}
 	if opts.KustomizePath != "" {
-		stdout, _, err := (&utils.WorkDir{}).RunCommand(opts.KustomizePath, "build", dirPath)
+		if opts.FileSystem != nil {
+			return "", errors.New("kustomize binary can only build kustomizations on disk")
+		}
+		stdout, _, err := (&utils.WorkDir{}).RunCommand(ctx, opts.KustomizePath, kustomizeArgs(dirPath, opts)...)
 		if err != nil {
 			return "", err
 		}
 		return stdout, nil
 	}
-	fSys := filesys.MakeFsOnDisk()
-	k := krusty.MakeKustomizer(
-		krusty.MakeDefaultOptions(),
-	)
+	if ctx.Err() != nil {
+		return "", errors.WithStack(ctx.Err())
+	}
+	// The embedded kustomize can't be canceled, so the goroutine is left
+	// running in the background when the context is done until kustomize
+	// returns. It's cut off from the file system shared with the later builds.
+	type output struct {
+		yaml string
+		err  error
+	}
+	afs := newAbandonableFs(fSys)
+	ch := make(chan output, 1)
+	go func() {
+		yaml, err := runKustomizer(afs, dirPath, opts)
+		ch <- output{yaml, err}
+	}()
+	select {
+	case out := <-ch:
+		return out.yaml, out.err
+	case <-ctx.Done():
+		afs.abandon()
+		return "", errors.WithStack(ctx.Err())
+	}
+}
+
+func runKustomizer(fSys filesys.FileSystem, dirPath string, opts BuildOpts) (string, error) {
+	k := krusty.MakeKustomizer(krustyOptions(opts))
 	resMap, err := k.Run(fSys, dirPath)
 	if err != nil {
 		return "", errors.WithStack(err)
@@ -118,3 +508,41 @@ func Build(dirPath string, opts BuildOpts) (string, error) {
 	}
 	return string(bs), nil
 }
+
+func krustyOptions(opts BuildOpts) *krusty.Options {
+	kOpts := krusty.MakeDefaultOptions()
+	if opts.LoadRestrictions != types.LoadRestrictionsUnknown {
+		kOpts.LoadRestrictions = opts.LoadRestrictions
+	}
+	if opts.EnableAlphaPlugins {
+		kOpts.PluginConfig = types.EnabledPluginConfig(types.BploUseStaticallyLinked)
+		kOpts.PluginConfig.FnpLoadingOptions.EnableExec = opts.EnableExec
+	}
+	kOpts.PluginConfig.HelmConfig.Enabled = opts.EnableHelm
+	kOpts.PluginConfig.HelmConfig.Command = opts.HelmCommand
+	if kOpts.PluginConfig.HelmConfig.Command == "" {
+		kOpts.PluginConfig.HelmConfig.Command = "helm"
+	}
+	return kOpts
+}
+
+// kustomizeArgs returns the arguments of a kustomize binary equivalent to krustyOptions.
+func kustomizeArgs(dirPath string, opts BuildOpts) []string {
+	args := []string{"build", dirPath}
+	if opts.LoadRestrictions != types.LoadRestrictionsUnknown {
+		args = append(args, "--load-restrictor", opts.LoadRestrictions.String())
+	}
+	if opts.EnableHelm {
+		args = append(args, "--enable-helm")
+		if opts.HelmCommand != "" {
+			args = append(args, "--helm-command", opts.HelmCommand)
+		}
+	}
+	if opts.EnableAlphaPlugins {
+		args = append(args, "--enable-alpha-plugins")
+		if opts.EnableExec {
+			args = append(args, "--enable-exec")
+		}
+	}
+	return args
+}
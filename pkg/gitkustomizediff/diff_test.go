@@ -17,13 +17,20 @@ limitations under the License.
 package gitkustomizediff
 
 import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"regexp"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"sigs.k8s.io/kustomize/api/types"
+	"sigs.k8s.io/kustomize/kyaml/filesys"
 )
 
 func TestBuild(t *testing.T) {
@@ -41,13 +48,53 @@ spec:
 `, "\n")
 
 	fixturesDirPath := filepath.Join(wd, "fixtures", "diff", "base", "sub1")
-	actualYaml, err := Build(fixturesDirPath, BuildOpts{})
+	actualYaml, err := Build(context.Background(), fixturesDirPath, BuildOpts{})
 	if !assert.NoError(t, err) {
 		t.FailNow()
 	}
 	assert.Equal(t, expectedYaml, actualYaml)
 }
 
+func TestBuildLoadRestrictions(t *testing.T) {
+	wd, _ := os.Getwd()
+
+	fixturesDirPath := filepath.Join(wd, "fixtures", "build", "load-restrictions", "overlay")
+	_, err := Build(context.Background(), fixturesDirPath, BuildOpts{})
+	assert.Error(t, err)
+
+	actualYaml, err := Build(context.Background(), fixturesDirPath, BuildOpts{LoadRestrictions: types.LoadRestrictionsNone})
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	assert.Contains(t, actualYaml, "name: outside")
+}
+
+func TestBuildEnableExec(t *testing.T) {
+	wd, _ := os.Getwd()
+
+	fixturesDirPath := filepath.Join(wd, "fixtures", "build", "load-restrictions", "overlay")
+	_, err := Build(context.Background(), fixturesDirPath, BuildOpts{EnableExec: true})
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "exec function plugins require the alpha plugins")
+	}
+}
+
+func TestKustomizeArgs(t *testing.T) {
+	assert.Equal(t, []string{"build", "dir"}, kustomizeArgs("dir", BuildOpts{}))
+	assert.Equal(t, []string{
+		"build", "dir",
+		"--load-restrictor", "LoadRestrictionsNone",
+		"--enable-helm", "--helm-command", "helm3",
+		"--enable-alpha-plugins", "--enable-exec",
+	}, kustomizeArgs("dir", BuildOpts{
+		LoadRestrictions:   types.LoadRestrictionsNone,
+		EnableHelm:         true,
+		HelmCommand:        "helm3",
+		EnableAlphaPlugins: true,
+		EnableExec:         true,
+	}))
+}
+
 func TestDiff(t *testing.T) {
 	wd, _ := os.Getwd()
 
@@ -64,7 +111,7 @@ func TestDiff(t *testing.T) {
 
 	baseDirPath := filepath.Join(wd, "fixtures", "diff", "base")
 	targetDirPath := filepath.Join(wd, "fixtures", "diff", "target")
-	diffMap, err := Diff(baseDirPath, targetDirPath, DiffOpts{})
+	diffMap, err := Diff(context.Background(), baseDirPath, targetDirPath, DiffOpts{})
 	if !assert.NoError(t, err) {
 		t.FailNow()
 	}
@@ -72,4 +119,214 @@ func TestDiff(t *testing.T) {
 	assert.Equal(t, expectedSub1Diff, diffMap.Results["sub1"].(*DiffContent).ToString())
 	assert.Equal(t, expectedSub2Diff, diffMap.Results["sub2"].(*DiffContent).ToString())
 	assert.Regexp(t, expectedInvalidErrorRegexp, diffMap.Results["invalid"].(*DiffError).Error().Error())
+	assert.Equal(t, "both", diffMap.Results["invalid"].(*DiffError).Side())
+	assert.Equal(t, ErrorCategoryMissingFile, diffMap.Results["invalid"].(*DiffError).Category())
+	assert.Equal(t, BuildStatusBrokenOnBoth, diffMap.Results["invalid"].(*DiffError).Status())
+	assert.Equal(t, []string{"invalid"}, diffMap.DirsByBuildStatus(BuildStatusBrokenOnBoth))
+	assert.NoError(t, diffMap.TargetBuilds["sub1"].Err)
+	assert.Contains(t, diffMap.TargetBuilds["sub1"].Yaml, "name: sub1-modified")
+}
+
+func TestDiffDirs(t *testing.T) {
+	baseFSys := filesys.MakeFsInMemory()
+	targetFSys := filesys.MakeFsInMemory()
+	assert.NoError(t, baseFSys.WriteFile("/repo/a/x/kustomization.yaml", []byte("namePrefix: base-\n")))
+	assert.NoError(t, baseFSys.WriteFile("/repo/b/kustomization.yaml", []byte("namePrefix: base-\n")))
+	assert.NoError(t, baseFSys.WriteFile("/repo/c/kustomization.yaml", []byte("namePrefix: base-\n")))
+	assert.NoError(t, targetFSys.WriteFile("/repo/a/x/kustomization.yaml", []byte("namePrefix: target-\n")))
+	assert.NoError(t, targetFSys.WriteFile("/repo/c/kustomization.yaml", []byte("namePrefix: target-\n")))
+	assert.NoError(t, targetFSys.WriteFile("/repo/d/kustomization.yaml", []byte("namePrefix: target-\n")))
+
+	// b only exists in the base and d isn't listed.
+	diffMap, err := Diff(context.Background(), "/repo", "/repo", DiffOpts{
+		Dirs:             []string{"b", "a"},
+		BaseFileSystem:   baseFSys,
+		TargetFileSystem: targetFSys,
+	})
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	assert.Equal(t, []string{"a/x", "b"}, diffMap.Dirs())
+}
+
+func TestDiffBuildStatus(t *testing.T) {
+	baseFSys := filesys.MakeFsInMemory()
+	targetFSys := filesys.MakeFsInMemory()
+	for _, fSys := range []filesys.FileSystem{baseFSys, targetFSys} {
+		assert.NoError(t, fSys.WriteFile("/repo/pod.yaml", []byte("apiVersion: v1\nkind: Pod\nmetadata:\n  name: pod\n")))
+	}
+	assert.NoError(t, baseFSys.WriteFile("/repo/broken/kustomization.yaml", []byte("resources:\n- ../pod.yaml\n")))
+	assert.NoError(t, targetFSys.WriteFile("/repo/broken/kustomization.yaml", []byte("resources:\n- missing.yaml\n")))
+	assert.NoError(t, baseFSys.WriteFile("/repo/fixed/kustomization.yaml", []byte("resources:\n- missing.yaml\n")))
+	assert.NoError(t, targetFSys.WriteFile("/repo/fixed/kustomization.yaml", []byte("resources: []\n")))
+
+	diffMap, err := Diff(context.Background(), "/repo", "/repo", DiffOpts{
+		LoadRestrictions: types.LoadRestrictionsNone,
+		BaseFileSystem:   baseFSys,
+		TargetFileSystem: targetFSys,
+	})
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	assert.Equal(t, BuildStatusNewlyBroken, diffMap.Results["broken"].(*DiffError).Status())
+	assert.Equal(t, BuildStatusFixed, diffMap.Results["fixed"].(*DiffError).Status())
+	assert.Contains(t, diffMap.BaseBuilds["broken"].Yaml, "name: pod")
+	assert.Contains(t, diffMap.Results["broken"].AsMarkdown(), "Newly broken by this change")
+}
+
+func TestDiffAmbiguousKustomization(t *testing.T) {
+	baseFSys := filesys.MakeFsInMemory()
+	targetFSys := filesys.MakeFsInMemory()
+	assert.NoError(t, baseFSys.WriteFile("/repo/app/Kustomization", []byte("resources: []\n")))
+	assert.NoError(t, targetFSys.WriteFile("/repo/app/Kustomization", []byte("resources: []\n")))
+	assert.NoError(t, targetFSys.WriteFile("/repo/app/kustomization.yaml", []byte("resources: []\n")))
+
+	diffMap, err := Diff(context.Background(), "/repo", "/repo", DiffOpts{
+		BaseFileSystem:   baseFSys,
+		TargetFileSystem: targetFSys,
+	})
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	assert.Equal(t, []string{"app"}, diffMap.Dirs())
+	assert.Equal(t, []string{"app has multiple kustomization files in target: kustomization.yaml, Kustomization"}, diffMap.Warnings)
+}
+
+func TestDiffBuildTimeout(t *testing.T) {
+	wd, _ := os.Getwd()
+
+	tmpDirPath, err := ioutil.TempDir("", "kustomize-diff-test-")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer os.RemoveAll(tmpDirPath)
+	kustomizePath := filepath.Join(tmpDirPath, "kustomize")
+	err = ioutil.WriteFile(kustomizePath, []byte("#!/bin/sh\nsleep 10\n"), 0700)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+
+	baseDirPath := filepath.Join(wd, "fixtures", "diff", "base")
+	targetDirPath := filepath.Join(wd, "fixtures", "diff", "target")
+	start := time.Now()
+	diffMap, err := Diff(context.Background(), baseDirPath, targetDirPath, DiffOpts{
+		IncludeRegexp: regexp.MustCompile("sub1$"),
+		KustomizePath: kustomizePath,
+		BuildTimeout:  100 * time.Millisecond,
+	})
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	assert.Less(t, int64(time.Since(start)), int64(5*time.Second))
+	r := diffMap.Results["sub1"].(*DiffError)
+	assert.Equal(t, ErrorCategoryTimeout, r.Category())
+	assert.Equal(t, BuildStatusTimedOut, r.Status())
+	assert.Equal(t, "both", r.Side())
+	assert.Equal(t, []string{"sub1"}, diffMap.DirsByBuildStatus(BuildStatusTimedOut))
+	assert.Contains(t, r.AsMarkdown(), ":hourglass: Timed out building the base and target")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = Build(ctx, filepath.Join(baseDirPath, "sub1"), BuildOpts{})
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+type recordingLogger struct {
+	messages []string
+}
+
+func (l *recordingLogger) Debugf(format string, args ...interface{}) {
+	l.messages = append(l.messages, "debug: "+fmt.Sprintf(format, args...))
+}
+
+func (l *recordingLogger) Infof(format string, args ...interface{}) {
+	l.messages = append(l.messages, "info: "+fmt.Sprintf(format, args...))
+}
+
+func (l *recordingLogger) Warnf(format string, args ...interface{}) {
+	l.messages = append(l.messages, "warn: "+fmt.Sprintf(format, args...))
+}
+
+func TestDiffSelectorChanges(t *testing.T) {
+	fSys := filesys.MakeFsInMemory()
+	workloads := "apiVersion: apps/v1\nkind: Deployment\nmetadata:\n  name: web\nspec:\n  selector:\n    matchLabels:\n      app: %[1]s\n" +
+		"---\napiVersion: batch/v1\nkind: Job\nmetadata:\n  name: migrate\nspec:\n  selector:\n    matchLabels:\n      app: %[1]s\n"
+	for path, content := range map[string]string{
+		"/base/kustomization.yaml":   "resources:\n- workloads.yaml\n",
+		"/base/workloads.yaml":       fmt.Sprintf(workloads, "v1"),
+		"/target/kustomization.yaml": "resources:\n- workloads.yaml\n",
+		"/target/workloads.yaml":     fmt.Sprintf(workloads, "v2"),
+	} {
+		if !assert.NoError(t, fSys.WriteFile(path, []byte(content))) {
+			t.FailNow()
+		}
+	}
+	diffMap, err := Diff(context.Background(), "/base", "/target", DiffOpts{BaseFileSystem: fSys, TargetFileSystem: fSys})
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	// The selector of the Deployment is reported only as a risk.
+	assert.Equal(t, []Risk{
+		{Category: RiskCategorySelectorChange, Resource: "Deployment.apps/web", Message: "spec.selector is changed, which requires to recreate the resource"},
+	}, diffMap.Risks["."])
+	assert.Equal(t, []ImmutableFieldChange{
+		{Resource: "Job.batch/migrate", Field: "spec.selector"},
+	}, diffMap.ImmutableFieldChanges["."])
+}
+
+func TestDiffLoggerAndProgress(t *testing.T) {
+	wd, _ := os.Getwd()
+
+	logger := &recordingLogger{}
+	events := make([]ProgressEvent, 0)
+	baseDirPath := filepath.Join(wd, "fixtures", "diff", "base")
+	targetDirPath := filepath.Join(wd, "fixtures", "diff", "target")
+	_, err := Diff(context.Background(), baseDirPath, targetDirPath, DiffOpts{
+		Logger: logger,
+		Progress: func(event ProgressEvent) {
+			events = append(events, event)
+		},
+	})
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	assert.Equal(t, "info: Start diff", logger.messages[0])
+	assert.Contains(t, logger.messages, "debug: Build "+filepath.Join(baseDirPath, "sub1")+" with kustomize")
+	type simpleEvent struct {
+		Type  ProgressEventType
+		Dir   string
+		Done  int
+		Total int
+	}
+	simpleEvents := make([]simpleEvent, 0, len(events))
+	for _, event := range events {
+		assert.False(t, event.Time.IsZero())
+		simpleEvents = append(simpleEvents, simpleEvent{event.Type, event.Dir, event.Done, event.Total})
+	}
+	assert.Equal(t, []simpleEvent{
+		{ProgressEventBuildStarted, "invalid", 0, 3},
+		{ProgressEventBuildFinished, "invalid", 0, 3},
+		{ProgressEventDiffComputed, "invalid", 1, 3},
+		{ProgressEventBuildStarted, "sub1", 1, 3},
+		{ProgressEventBuildFinished, "sub1", 1, 3},
+		{ProgressEventDiffComputed, "sub1", 2, 3},
+		{ProgressEventBuildStarted, "sub2", 2, 3},
+		{ProgressEventBuildFinished, "sub2", 2, 3},
+		{ProgressEventDiffComputed, "sub2", 3, 3},
+	}, simpleEvents)
+}
+
+func TestProgressEventMarshalJSON(t *testing.T) {
+	bs, err := json.Marshal(ProgressEvent{
+		Type:     ProgressEventBuildFinished,
+		Time:     time.Date(2021, 1, 2, 3, 4, 5, 0, time.UTC),
+		Dir:      "sub1",
+		Done:     1,
+		Total:    3,
+		Duration: 1500 * time.Millisecond,
+	})
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	assert.JSONEq(t, `{"type":"build finished","time":"2021-01-02T03:04:05Z","dir":"sub1","done":1,"total":3,"duration":1.5}`, string(bs))
 }
@@ -0,0 +1,138 @@
+/*
+Copyright 2021 Daisuke Taniwaki.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gitkustomizediff
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"sigs.k8s.io/kustomize/kyaml/filesys"
+)
+
+// DirPair is a pair of directories to diff.
+type DirPair struct {
+	Base   string
+	Target string
+}
+
+func (p DirPair) String() string {
+	return fmt.Sprintf("%s...%s", p.Base, p.Target)
+}
+
+// ExpandDirPattern expands a pattern with a brace, e.g. overlays/{staging,prod},
+// into the pairs of the first directory and each of the others.
+func ExpandDirPattern(pattern string) ([]DirPair, error) {
+	start := strings.Index(pattern, "{")
+	end := strings.Index(pattern, "}")
+	if start < 0 || end < start {
+		return nil, errors.Errorf("Invalid pattern: %s", pattern)
+	}
+	prefix, suffix := pattern[:start], pattern[end+1:]
+	if strings.ContainsAny(prefix+suffix, "{}") {
+		return nil, errors.Errorf("Only one brace is supported: %s", pattern)
+	}
+	names := strings.Split(pattern[start+1:end], ",")
+	if len(names) < 2 {
+		return nil, errors.Errorf("Pattern needs at least two directories: %s", pattern)
+	}
+	pairs := make([]DirPair, 0, len(names)-1)
+	for _, name := range names[1:] {
+		pairs = append(pairs, DirPair{Base: prefix + names[0] + suffix, Target: prefix + name + suffix})
+	}
+	return pairs, nil
+}
+
+// EnvDiff builds and diffs the pairs of the directories relative to dirPath,
+// e.g. the overlays of environments. The targets in the directories are paired
+// by the paths relative to the directories, so a pair of directories of
+// multiple kustomizations is diffed kustomization by kustomization. The
+// results are keyed by the pairs. The risks and the immutable field changes
+// aren't reported because the pairs aren't changes.
+func EnvDiff(ctx context.Context, dirPath string, pairs []DirPair, opts DiffOpts) (*DiffMap, error) {
+	logger := loggerOrNop(opts.Logger)
+	logger.Infof("Start env diff")
+	d := newDiffer(opts)
+	d.envPairs = make(map[string]DirPair)
+	d.baseRoot, d.targetRoot = dirPath, dirPath
+	d.diffMap.Env = true
+	keys := make([]string, 0, len(pairs))
+	absPairs := make([]DirPair, 0, len(pairs))
+	for _, pair := range pairs {
+		pair = DirPair{Base: filepath.Clean(pair.Base), Target: filepath.Clean(pair.Target)}
+		if err := checkEnvDir(d.baseFSys, dirPath, pair.Base); err != nil {
+			return nil, err
+		}
+		if err := checkEnvDir(d.targetFSys, dirPath, pair.Target); err != nil {
+			return nil, err
+		}
+		relDirs, err := d.envKustomizeDirs(dirPath, pair)
+		if err != nil {
+			return nil, err
+		}
+		for _, relDir := range relDirs {
+			subPair := DirPair{Base: filepath.Join(pair.Base, relDir), Target: filepath.Join(pair.Target, relDir)}
+			keys = append(keys, subPair.String())
+			d.envPairs[subPair.String()] = subPair
+			absPairs = append(absPairs, DirPair{Base: filepath.Join(dirPath, subPair.Base), Target: filepath.Join(dirPath, subPair.Target)})
+		}
+	}
+	d.diffAll(ctx, keys, absPairs)
+	d.opts.Progress.emit(ProgressEvent{Type: ProgressEventRunFinished, Timings: &Timings{Kustomizations: d.diffMap.KustomizationTimings()}})
+	return d.diffMap, nil
+}
+
+// envKustomizeDirs returns the targets in either directory of the pair relative
+// to the directories. The pair itself is diffed if no target is found so that
+// the build errors are reported.
+func (d *differ) envKustomizeDirs(dirPath string, pair DirPair) ([]string, error) {
+	listOpts := d.listOpts(dirPath)
+	baseKDirs, err := listKustomizeDirs(d.baseFSys, filepath.Join(dirPath, pair.Base), nil, listOpts)
+	if err != nil {
+		return nil, err
+	}
+	targetKDirs, err := listKustomizeDirs(d.targetFSys, filepath.Join(dirPath, pair.Target), nil, listOpts)
+	if err != nil {
+		return nil, err
+	}
+	kDirSet := make(map[string]struct{})
+	for _, kDir := range append(baseKDirs, targetKDirs...) {
+		kDirSet[kDir] = struct{}{}
+	}
+	if len(kDirSet) == 0 {
+		return []string{"."}, nil
+	}
+	kDirs := make([]string, 0, len(kDirSet))
+	for kDir := range kDirSet {
+		kDirs = append(kDirs, kDir)
+	}
+	sort.Strings(kDirs)
+	return kDirs, nil
+}
+
+func checkEnvDir(fSys filesys.FileSystem, dirPath, path string) error {
+	if filepath.IsAbs(path) || path == ".." || strings.HasPrefix(path, ".."+string(filepath.Separator)) {
+		return errors.Errorf("%s is not a directory in %s", path, dirPath)
+	}
+	if !fSys.IsDir(filepath.Join(dirPath, path)) {
+		return errors.Errorf("%s is not found in %s", path, dirPath)
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+/*
+Copyright 2021 Daisuke Taniwaki.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gitkustomizediff
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"sigs.k8s.io/kustomize/kyaml/filesys"
+)
+
+func TestExpandDirPattern(t *testing.T) {
+	pairs, err := ExpandDirPattern("overlays/{staging,prod,dev}/app")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	assert.Equal(t, []DirPair{
+		{Base: "overlays/staging/app", Target: "overlays/prod/app"},
+		{Base: "overlays/staging/app", Target: "overlays/dev/app"},
+	}, pairs)
+	assert.Equal(t, "overlays/staging/app...overlays/prod/app", pairs[0].String())
+
+	for _, pattern := range []string{"overlays/prod", "overlays/{prod}", "overlays/}prod,dev{", "{a,b}/{c,d}"} {
+		_, err = ExpandDirPattern(pattern)
+		assert.Error(t, err, pattern)
+	}
+}
+
+func TestEnvDiff(t *testing.T) {
+	wd, _ := os.Getwd()
+	dirPath := filepath.Join(wd, "fixtures", "diff")
+
+	diffMap, err := EnvDiff(context.Background(), dirPath, []DirPair{
+		{Base: "base/sub1", Target: "base/sub2"},
+		{Base: "base/sub1/", Target: "target/sub1"},
+		{Base: "base/sub2", Target: "target/invalid"},
+	}, DiffOpts{})
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	assert.Equal(t, []string{"base/sub1...base/sub2", "base/sub1...target/sub1", "base/sub2...target/invalid"}, diffMap.Dirs())
+	assert.Equal(t, []ImageChange{
+		{Resource: "Pod/sub1", Container: "sub1", Old: "nginx:latest"},
+		{Resource: "Pod/sub2", Container: "sub2", New: "nginx:latest"},
+	}, diffMap.ImageChanges["base/sub1...base/sub2"])
+	assert.Contains(t, diffMap.Results["base/sub1...target/sub1"].ToString(), "+    name: sub1-modified")
+	assert.Equal(t, BuildStatusNewlyBroken, diffMap.Results["base/sub2...target/invalid"].(*DiffError).Status())
+	assert.Empty(t, diffMap.Risks)
+	assert.Empty(t, diffMap.ImmutableFieldChanges)
+	// The results don't refer to a change.
+	markdown := diffMap.Results["base/sub2...target/invalid"].AsMarkdown()
+	assert.True(t, strings.HasPrefix(markdown, ":boom: Fails only in target/invalid (missing file)\n\n[target/invalid]\n"), markdown)
+	assert.NotContains(t, markdown, "this change")
+	assert.Equal(t, "fails only in the target directory", diffMap.StatusLabel(BuildStatusNewlyBroken))
+	assert.Equal(t, "fails in both directories", diffMap.StatusLabel(BuildStatusBrokenOnBoth))
+	assert.Equal(t, "newly broken", NewDiffMap().StatusLabel(BuildStatusNewlyBroken))
+
+	// The kustomizations in the directories are paired and filtered.
+	diffMap, err = EnvDiff(context.Background(), dirPath, []DirPair{{Base: "base", Target: "target"}}, DiffOpts{ExcludeRegexp: regexp.MustCompile("invalid")})
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	assert.Equal(t, []string{"base/sub1...target/sub1", "base/sub2...target/sub2"}, diffMap.Dirs())
+
+	_, err = EnvDiff(context.Background(), dirPath, []DirPair{{Base: "base/sub1", Target: "base/missing"}}, DiffOpts{})
+	assert.Error(t, err)
+	_, err = EnvDiff(context.Background(), dirPath, []DirPair{{Base: "../diff/base/sub1", Target: "base/sub2"}}, DiffOpts{})
+	assert.Error(t, err)
+}
+
+func TestEnvDiffRisks(t *testing.T) {
+	fSys := filesys.MakeFsInMemory()
+	pvc := "apiVersion: v1\nkind: PersistentVolumeClaim\nmetadata:\n  name: data\n"
+	for path, content := range map[string]string{
+		"/envs/staging/kustomization.yaml": "resources:\n- pvc.yaml\n",
+		"/envs/staging/pvc.yaml":           pvc,
+		"/envs/prod/kustomization.yaml":    "resources:\n- pvc.yaml\nnamespace: prod\n",
+		"/envs/prod/pvc.yaml":              pvc,
+	} {
+		if !assert.NoError(t, fSys.WriteFile(path, []byte(content))) {
+			t.FailNow()
+		}
+	}
+	opts := DiffOpts{BaseFileSystem: fSys, TargetFileSystem: fSys}
+	diffMap, err := EnvDiff(context.Background(), "/envs", []DirPair{{Base: "staging", Target: "prod"}}, opts)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	assert.Contains(t, diffMap.Results["staging...prod"].ToString(), "+  namespace: prod")
+	assert.Empty(t, diffMap.Risks)
+
+	// The same directories are risky as a change.
+	diffMap, err = Diff(context.Background(), "/envs/staging", "/envs/prod", opts)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	assert.NotEmpty(t, diffMap.Risks)
+}
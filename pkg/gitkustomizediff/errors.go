@@ -0,0 +1,99 @@
+/*
+Copyright 2021 Daisuke Taniwaki.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gitkustomizediff
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/dtaniwaki/git-kustomize-diff/pkg/utils"
+	"github.com/pkg/errors"
+)
+
+type ErrorCategory string
+
+const (
+	ErrorCategoryMissingFile    ErrorCategory = "missing file"
+	ErrorCategoryInvalidYaml    ErrorCategory = "invalid YAML"
+	ErrorCategoryValidation     ErrorCategory = "kustomize validation"
+	ErrorCategoryCommand        ErrorCategory = "external command failure"
+	ErrorCategoryRemoteResource ErrorCategory = "remote resource fetch"
+	ErrorCategoryTimeout        ErrorCategory = "timeout"
+	ErrorCategoryUnknown        ErrorCategory = "unknown"
+)
+
+var errorCategoryKeywords = []struct {
+	category ErrorCategory
+	keywords []string
+}{
+	{ErrorCategoryRemoteResource, []string{"remote resource", "trouble cloning", "cloning git repo", "git fetch", "git clone", "unable to fetch", "http request", "dial tcp", "could not resolve host"}},
+	{ErrorCategoryMissingFile, []string{"doesn't exist", "does not exist", "no such file or directory", "must be a directory or file", "unable to find one of", "evalsymlink failure"}},
+	{ErrorCategoryInvalidYaml, []string{"yaml:", "json:", "malformed yaml", "error converting yaml", "cannot unmarshal", "mapping values are not allowed", "did not find expected"}},
+}
+
+// ClassifyError guesses the category of the error from its message.
+func ClassifyError(err error) ErrorCategory {
+	if err == nil {
+		return ErrorCategoryUnknown
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return ErrorCategoryTimeout
+	}
+	msg := strings.ToLower(err.Error())
+	for _, c := range errorCategoryKeywords {
+		for _, keyword := range c.keywords {
+			if strings.Contains(msg, keyword) {
+				return c.category
+			}
+		}
+	}
+	var cmdErr *utils.CommandError
+	if errors.As(err, &cmdErr) {
+		return ErrorCategoryCommand
+	}
+	return ErrorCategoryUnknown
+}
+
+// classifyBuildError classifies the error of a build. Errors without any
+// specific sign are considered to be rejected by kustomize.
+func classifyBuildError(err error) ErrorCategory {
+	category := ClassifyError(err)
+	if category == ErrorCategoryUnknown {
+		return ErrorCategoryValidation
+	}
+	return category
+}
+
+// ConciseErrorMessage returns the message of the error without the outputs of the external command.
+func ConciseErrorMessage(err error) string {
+	var cmdErr *utils.CommandError
+	if errors.As(err, &cmdErr) {
+		msg := cmdErr.InternalError.Error()
+		lines := strings.Split(strings.TrimSpace(cmdErr.Stderr), "\n")
+		if lastLine := strings.TrimSpace(lines[len(lines)-1]); lastLine != "" {
+			msg = fmt.Sprintf("%s: %s", msg, lastLine)
+		}
+		return msg
+	}
+	return err.Error()
+}
+
+// DetailedErrorMessage returns the message of the error with the outputs of the external command and the stack trace.
+func DetailedErrorMessage(err error) string {
+	return fmt.Sprintf("%+v", err)
+}
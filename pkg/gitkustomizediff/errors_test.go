@@ -0,0 +1,92 @@
+/*
+Copyright 2021 Daisuke Taniwaki.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gitkustomizediff
+
+import (
+	"context"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"github.com/dtaniwaki/git-kustomize-diff/pkg/utils"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClassifyError(t *testing.T) {
+	cmdErr := errors.WithStack(&utils.CommandError{
+		InternalError: &exec.ExitError{},
+		Stdout:        "",
+		Stderr:        "Error: something went wrong\n",
+	})
+	assert.Equal(t, ErrorCategoryCommand, ClassifyError(cmdErr))
+	assert.Equal(t, ErrorCategoryMissingFile, ClassifyError(errors.New("accumulating resources: 'pod.yaml' doesn't exist")))
+	assert.Equal(t, ErrorCategoryInvalidYaml, ClassifyError(errors.New("yaml: line 3: mapping values are not allowed in this context")))
+	assert.Equal(t, ErrorCategoryRemoteResource, ClassifyError(errors.New("trouble cloning github.com/example/repo")))
+	assert.Equal(t, ErrorCategoryUnknown, ClassifyError(errors.New("something")))
+	assert.Equal(t, ErrorCategoryValidation, classifyBuildError(errors.New("may not add resource with an already registered id")))
+}
+
+func TestDiffErrorAsMarkdown(t *testing.T) {
+	err := errors.WithStack(&utils.CommandError{
+		InternalError: errors.New("exit status 1"),
+		Stdout:        "stdout",
+		Stderr:        "line 1\nError: something went wrong\n",
+	})
+	assert.Equal(t, "exit status 1: Error: something went wrong", ConciseErrorMessage(err))
+
+	r := newBuildError(nil, err, false)
+	assert.Equal(t, "target", r.Side())
+	assert.Equal(t, ":boom: Newly broken by this change (external command failure)\n\n[target]\n```\nexit status 1: Error: something went wrong\n```", r.AsMarkdown())
+
+	r = newBuildError(err, err, true)
+	assert.Equal(t, "both", r.Side())
+	assert.Contains(t, r.AsMarkdown(), "[stdout]\nstdout")
+	assert.Contains(t, r.AsMarkdown(), "errors_test.go")
+}
+
+func TestDiffErrorTimedOut(t *testing.T) {
+	timeoutErr := errors.WithStack(context.DeadlineExceeded)
+	validationErr := errors.New("may not add resource with an already registered id")
+
+	r := newBuildError(validationErr, timeoutErr, false)
+	assert.Equal(t, BuildStatusTimedOut, r.Status())
+	assert.Equal(t, "target", r.TimedOutSide())
+	assert.Equal(t, ErrorCategoryValidation, r.Category())
+	assert.Equal(t, ErrorCategoryValidation, r.BaseCategory())
+	assert.Equal(t, ErrorCategoryTimeout, r.TargetCategory())
+	assert.True(t, strings.HasPrefix(r.AsMarkdown(), ":hourglass: Timed out building the target\n\n:x: Failed to build the base (kustomize validation)\n\n[base]\n"), r.AsMarkdown())
+
+	r = newBuildError(timeoutErr, validationErr, false)
+	assert.Equal(t, BuildStatusTimedOut, r.Status())
+	assert.Equal(t, "base", r.TimedOutSide())
+	assert.Equal(t, ErrorCategoryValidation, r.Category())
+	assert.True(t, strings.HasPrefix(r.AsMarkdown(), ":hourglass: Timed out building the base\n\n:x: Failed to build the target (kustomize validation)\n\n[base]\n"), r.AsMarkdown())
+	assert.Contains(t, r.AsMarkdown(), "[target]\n```\nmay not add resource")
+
+	r = newBuildError(timeoutErr, nil, false)
+	assert.Equal(t, "base", r.TimedOutSide())
+	assert.Equal(t, ErrorCategoryTimeout, r.Category())
+	assert.True(t, strings.HasPrefix(r.AsMarkdown(), ":hourglass: Timed out building the base\n\n[base]\n"), r.AsMarkdown())
+
+	r = newEnvBuildError(DirPair{Base: "staging", Target: "prod"}, timeoutErr, validationErr, false)
+	assert.True(t, strings.HasPrefix(r.AsMarkdown(), ":hourglass: Timed out building staging\n\n:x: Fails in prod (kustomize validation)\n\n[staging]\n"), r.AsMarkdown())
+
+	r = newBuildError(validationErr, nil, false)
+	assert.Equal(t, "", r.TimedOutSide())
+	assert.Equal(t, BuildStatusFixed, r.Status())
+}
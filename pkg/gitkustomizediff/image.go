@@ -0,0 +1,143 @@
+/*
+Copyright 2021 Daisuke Taniwaki.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gitkustomizediff
+
+import (
+	"fmt"
+)
+
+// containerFields are the fields of a pod spec having containers.
+var containerFields = []string{"initContainers", "containers", "ephemeralContainers"}
+
+// ImageChange is a change of the image of a container.
+// Old is empty for an added container and New is empty for a removed one.
+type ImageChange struct {
+	Resource  string
+	Container string
+	Old       string
+	New       string
+}
+
+func (c ImageChange) String() string {
+	return fmt.Sprintf("%s (%s): %s → %s", c.Resource, c.Container, displayImage(c.Old), displayImage(c.New))
+}
+
+func displayImage(image string) string {
+	if image == "" {
+		return "(none)"
+	}
+	return image
+}
+
+type containerImage struct {
+	resource  string
+	container string
+	image     string
+}
+
+// ImageChanges returns the changes of the container images from the base build to the target build.
+func ImageChanges(baseYaml, targetYaml string) ([]ImageChange, error) {
+	baseImages, err := containerImages(baseYaml)
+	if err != nil {
+		return nil, err
+	}
+	targetImages, err := containerImages(targetYaml)
+	if err != nil {
+		return nil, err
+	}
+	type key struct {
+		resource  string
+		container string
+	}
+	targetByKey := make(map[key]string, len(targetImages))
+	for _, ci := range targetImages {
+		targetByKey[key{ci.resource, ci.container}] = ci.image
+	}
+	changes := make([]ImageChange, 0)
+	seen := make(map[key]bool, len(baseImages))
+	for _, ci := range baseImages {
+		k := key{ci.resource, ci.container}
+		seen[k] = true
+		if newImage := targetByKey[k]; newImage != ci.image {
+			changes = append(changes, ImageChange{Resource: ci.resource, Container: ci.container, Old: ci.image, New: newImage})
+		}
+	}
+	for _, ci := range targetImages {
+		if !seen[key{ci.resource, ci.container}] {
+			changes = append(changes, ImageChange{Resource: ci.resource, Container: ci.container, New: ci.image})
+		}
+	}
+	return withoutRenamedContainers(changes), nil
+}
+
+// withoutRenamedContainers drops the pairs of a removed container and an added
+// container of the same image in the same resource.
+func withoutRenamedContainers(changes []ImageChange) []ImageChange {
+	dropped := make(map[int]bool)
+	for i, removed := range changes {
+		if removed.New != "" {
+			continue
+		}
+		for j, added := range changes {
+			if added.Old == "" && !dropped[j] && added.Resource == removed.Resource && added.New == removed.Old {
+				dropped[i] = true
+				dropped[j] = true
+				break
+			}
+		}
+	}
+	filtered := make([]ImageChange, 0, len(changes))
+	for i, change := range changes {
+		if !dropped[i] {
+			filtered = append(filtered, change)
+		}
+	}
+	return filtered
+}
+
+// containerImages returns the images of the containers in the pod specs of the resources.
+func containerImages(s string) ([]containerImage, error) {
+	resources, err := parseResources(s)
+	if err != nil {
+		return nil, err
+	}
+	images := make([]containerImage, 0)
+	for _, rn := range resources {
+		data, err := resourceData(rn)
+		if err != nil {
+			return nil, err
+		}
+		var podSpec interface{}
+		switch rn.GetKind() {
+		case "Pod":
+			podSpec = lookupValue(data, "spec")
+		case "CronJob":
+			podSpec = lookupValue(data, "spec", "jobTemplate", "spec", "template", "spec")
+		default:
+			podSpec = lookupValue(data, "spec", "template", "spec")
+		}
+		for _, field := range containerFields {
+			containers, _ := lookupValue(podSpec, field).([]interface{})
+			for _, container := range containers {
+				name, _ := lookupValue(container, "name").(string)
+				image, _ := lookupValue(container, "image").(string)
+				images = append(images, containerImage{resource: resourceID(rn), container: name, image: image})
+			}
+		}
+	}
+	return images, nil
+}
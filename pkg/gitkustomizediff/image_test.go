@@ -0,0 +1,135 @@
+/*
+Copyright 2021 Daisuke Taniwaki.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gitkustomizediff
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestImageChanges(t *testing.T) {
+	baseYaml := strings.TrimLeft(`
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+  namespace: default
+spec:
+  template:
+    spec:
+      initContainers:
+      - name: init
+        image: busybox:1.33
+      containers:
+      - name: web
+        image: nginx:1.20
+      - name: sidecar
+        image: envoy:1.18
+---
+apiVersion: batch/v1beta1
+kind: CronJob
+metadata:
+  name: backup
+spec:
+  jobTemplate:
+    spec:
+      template:
+        spec:
+          containers:
+          - name: backup
+            image: backup:v1
+---
+apiVersion: v1
+kind: Pod
+metadata:
+  name: debug
+spec:
+  containers:
+  - name: debug
+    image: alpine:3.13
+`, "\n")
+	targetYaml := strings.TrimLeft(`
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+  namespace: default
+spec:
+  template:
+    spec:
+      initContainers:
+      - name: init
+        image: busybox:1.33
+      containers:
+      - name: web
+        image: nginx:1.21
+      - name: proxy
+        image: envoy:1.18
+      ephemeralContainers:
+      - name: debugger
+        image: busybox:1.34
+---
+apiVersion: batch/v1beta1
+kind: CronJob
+metadata:
+  name: backup
+spec:
+  jobTemplate:
+    spec:
+      template:
+        spec:
+          containers:
+          - name: backup
+            image: backup:v2
+---
+apiVersion: v1
+kind: Pod
+metadata:
+  name: debug
+spec:
+  containers:
+  - name: debug
+    image: alpine:3.13
+`, "\n")
+
+	changes, err := ImageChanges(baseYaml, targetYaml)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	assert.Equal(t, []ImageChange{
+		{Resource: "Deployment.apps/default/web", Container: "web", Old: "nginx:1.20", New: "nginx:1.21"},
+		{Resource: "CronJob.batch/backup", Container: "backup", Old: "backup:v1", New: "backup:v2"},
+		{Resource: "Deployment.apps/default/web", Container: "debugger", New: "busybox:1.34"},
+	}, changes)
+	assert.Equal(t, "Deployment.apps/default/web (web): nginx:1.20 → nginx:1.21", changes[0].String())
+	assert.Equal(t, "Deployment.apps/default/web (debugger): (none) → busybox:1.34", changes[2].String())
+
+	changes, err = ImageChanges(baseYaml, "")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	assert.Equal(t, ImageChange{Resource: "Deployment.apps/default/web", Container: "sidecar", Old: "envoy:1.18"}, changes[2])
+	assert.Equal(t, "Deployment.apps/default/web (sidecar): envoy:1.18 → (none)", changes[2].String())
+
+	changes, err = ImageChanges(baseYaml, baseYaml)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	assert.Empty(t, changes)
+}
@@ -0,0 +1,130 @@
+/*
+Copyright 2021 Daisuke Taniwaki.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gitkustomizediff
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+// ImmutableField is a field which the API server rejects to update.
+type ImmutableField struct {
+	// Kind is qualified by the API group unless it's in the core group, e.g.
+	// Deployment.apps and Service.
+	Kind string
+	Path []string
+}
+
+func (f ImmutableField) String() string {
+	return strings.Join(f.Path, ".")
+}
+
+// DefaultImmutableFields are the well-known immutable fields of the builtin kinds.
+var DefaultImmutableFields = []ImmutableField{
+	{Kind: "ClusterRoleBinding.rbac.authorization.k8s.io", Path: []string{"roleRef"}},
+	{Kind: "DaemonSet.apps", Path: []string{"spec", "selector"}},
+	{Kind: "Deployment.apps", Path: []string{"spec", "selector"}},
+	{Kind: "Job.batch", Path: []string{"spec", "selector"}},
+	{Kind: "Job.batch", Path: []string{"spec", "template"}},
+	{Kind: "PersistentVolumeClaim", Path: []string{"spec", "accessModes"}},
+	{Kind: "PersistentVolumeClaim", Path: []string{"spec", "storageClassName"}},
+	{Kind: "PersistentVolumeClaim", Path: []string{"spec", "volumeMode"}},
+	{Kind: "PersistentVolumeClaim", Path: []string{"spec", "volumeName"}},
+	{Kind: "ReplicaSet.apps", Path: []string{"spec", "selector"}},
+	{Kind: "RoleBinding.rbac.authorization.k8s.io", Path: []string{"roleRef"}},
+	{Kind: "Secret", Path: []string{"type"}},
+	{Kind: "Service", Path: []string{"spec", "clusterIP"}},
+	{Kind: "StatefulSet.apps", Path: []string{"spec", "podManagementPolicy"}},
+	{Kind: "StatefulSet.apps", Path: []string{"spec", "selector"}},
+	{Kind: "StatefulSet.apps", Path: []string{"spec", "serviceName"}},
+	{Kind: "StatefulSet.apps", Path: []string{"spec", "volumeClaimTemplates"}},
+	{Kind: "StorageClass.storage.k8s.io", Path: []string{"parameters"}},
+	{Kind: "StorageClass.storage.k8s.io", Path: []string{"provisioner"}},
+	{Kind: "StorageClass.storage.k8s.io", Path: []string{"reclaimPolicy"}},
+	{Kind: "StorageClass.storage.k8s.io", Path: []string{"volumeBindingMode"}},
+}
+
+type ImmutableFieldChange struct {
+	Resource string
+	Field    string
+}
+
+func (c ImmutableFieldChange) String() string {
+	return fmt.Sprintf("%s: %s is immutable, this change requires delete/recreate", c.Resource, c.Field)
+}
+
+// CheckImmutableFields returns the changes of the immutable fields of the
+// resources existing in both the base build and the target build.
+func CheckImmutableFields(baseYaml, targetYaml string, fields []ImmutableField) ([]ImmutableFieldChange, error) {
+	baseResources, err := parseResources(baseYaml)
+	if err != nil {
+		return nil, err
+	}
+	targetResources, err := parseResources(targetYaml)
+	if err != nil {
+		return nil, err
+	}
+	targetByID := make(map[string]*yaml.RNode, len(targetResources))
+	for _, rn := range targetResources {
+		targetByID[resourceID(rn)] = rn
+	}
+	changes := make([]ImmutableFieldChange, 0)
+	for _, baseRn := range baseResources {
+		id := resourceID(baseRn)
+		targetRn, ok := targetByID[id]
+		if !ok {
+			continue
+		}
+		changedFields, err := changedFields(baseRn, targetRn, fields)
+		if err != nil {
+			return nil, err
+		}
+		for _, field := range changedFields {
+			changes = append(changes, ImmutableFieldChange{Resource: id, Field: field.String()})
+		}
+	}
+	return changes, nil
+}
+
+// changedFields returns the fields of the resource kind changed between the resources.
+func changedFields(baseRn, targetRn *yaml.RNode, fields []ImmutableField) ([]ImmutableField, error) {
+	changed := make([]ImmutableField, 0)
+	var baseData, targetData interface{}
+	for _, field := range fields {
+		if field.Kind != groupKind(baseRn) {
+			continue
+		}
+		if baseData == nil {
+			var err error
+			baseData, err = resourceData(baseRn)
+			if err != nil {
+				return nil, err
+			}
+			targetData, err = resourceData(targetRn)
+			if err != nil {
+				return nil, err
+			}
+		}
+		if !reflect.DeepEqual(lookupValue(baseData, field.Path...), lookupValue(targetData, field.Path...)) {
+			changed = append(changed, field)
+		}
+	}
+	return changed, nil
+}
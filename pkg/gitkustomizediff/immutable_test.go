@@ -0,0 +1,162 @@
+/*
+Copyright 2021 Daisuke Taniwaki.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gitkustomizediff
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckImmutableFields(t *testing.T) {
+	baseYaml := strings.TrimLeft(`
+apiVersion: v1
+kind: Service
+metadata:
+  name: web
+spec:
+  clusterIP: 10.0.0.1
+  ports:
+  - port: 80
+---
+apiVersion: batch/v1
+kind: Job
+metadata:
+  name: migrate
+spec:
+  template:
+    spec:
+      containers:
+      - name: migrate
+        image: migrate:v1
+---
+apiVersion: apps/v1
+kind: StatefulSet
+metadata:
+  name: db
+spec:
+  replicas: 1
+  volumeClaimTemplates:
+  - metadata:
+      name: data
+    spec:
+      resources:
+        requests:
+          storage: 1Gi
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: config
+data:
+  foo: bar
+`, "\n")
+	targetYaml := strings.TrimLeft(`
+apiVersion: v1
+kind: Service
+metadata:
+  name: web
+spec:
+  clusterIP: 10.0.0.2
+  ports:
+  - port: 8080
+---
+apiVersion: batch/v1
+kind: Job
+metadata:
+  name: migrate
+spec:
+  template:
+    spec:
+      containers:
+      - name: migrate
+        image: migrate:v2
+---
+apiVersion: apps/v1
+kind: StatefulSet
+metadata:
+  name: db
+spec:
+  replicas: 3
+  volumeClaimTemplates:
+  - metadata:
+      name: data
+    spec:
+      resources:
+        requests:
+          storage: 1Gi
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: config
+data:
+  foo: baz
+`, "\n")
+
+	changes, err := CheckImmutableFields(baseYaml, targetYaml, DefaultImmutableFields)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	assert.Equal(t, []ImmutableFieldChange{
+		{Resource: "Service/web", Field: "spec.clusterIP"},
+		{Resource: "Job.batch/migrate", Field: "spec.template"},
+	}, changes)
+	assert.Equal(t, "Service/web: spec.clusterIP is immutable, this change requires delete/recreate", changes[0].String())
+
+	changes, err = CheckImmutableFields(baseYaml, "", DefaultImmutableFields)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	assert.Empty(t, changes)
+}
+
+func TestCheckImmutableFieldsGroups(t *testing.T) {
+	// A custom Deployment of the same name is another resource.
+	workloads := strings.TrimLeft(`
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+spec:
+  selector:
+    matchLabels:
+      app: web
+---
+apiVersion: example.com/v1
+kind: Deployment
+metadata:
+  name: web
+spec:
+  selector:
+    matchLabels:
+      app: %s
+`, "\n")
+	changes, err := CheckImmutableFields(fmt.Sprintf(workloads, "v1"), fmt.Sprintf(workloads, "v2"), DefaultImmutableFields)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	assert.Empty(t, changes)
+
+	risks, err := ClassifyRisks(fmt.Sprintf(workloads, "v1"), fmt.Sprintf(workloads, "v2"), DefaultRiskyKinds)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	assert.Empty(t, risks)
+}
@@ -0,0 +1,139 @@
+/*
+Copyright 2021 Daisuke Taniwaki.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gitkustomizediff
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/dtaniwaki/git-kustomize-diff/pkg/utils"
+	"github.com/pkg/errors"
+	"sigs.k8s.io/kustomize/kyaml/filesys"
+)
+
+// LogEntry is the change introduced by a commit.
+type LogEntry struct {
+	Commit  string
+	Subject string
+	Result  *RunResult
+}
+
+// Log runs the diff of each commit in the range from the base to the target
+// against its first parent. A root commit is diffed against an empty tree.
+// The builds are cached in a temporary directory unless CacheDir is set so
+// that the unchanged builds are reused.
+func Log(ctx context.Context, dirPaths []string, opts RunOpts) ([]LogEntry, error) {
+	logger := loggerOrNop(opts.Logger)
+	currentGitDir, relPaths, err := repoDirs(ctx, dirPaths, opts.GitPath)
+	if err != nil {
+		return nil, err
+	}
+	base, target, err := commitishes(ctx, currentGitDir, opts)
+	if err != nil {
+		return nil, err
+	}
+	baseCommit, err := currentGitDir.CommitHash(ctx, base)
+	if err != nil {
+		return nil, err
+	}
+	targetCommit, err := currentGitDir.CommitHash(ctx, target)
+	if err != nil {
+		return nil, err
+	}
+	commits, err := currentGitDir.RevList(ctx, baseCommit, targetCommit)
+	if err != nil {
+		return nil, err
+	}
+	logger.Infof("Found %d commit(s) in %s..%s", len(commits), baseCommit, targetCommit)
+
+	if opts.CacheDir == "" {
+		cacheDir, err := ioutil.TempDir("", "git-kustomize-diff-cache-")
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		defer os.RemoveAll(cacheDir)
+		opts.CacheDir = cacheDir
+	}
+	opts.AllowDirty = false
+
+	entries := make([]LogEntry, 0, len(commits))
+	for _, commit := range commits {
+		subject, err := currentGitDir.CommitSubject(ctx, commit)
+		if err != nil {
+			return nil, err
+		}
+		logger.Infof("Diff %s %s", commit, subject)
+		parent, err := currentGitDir.FirstParent(ctx, commit)
+		if err != nil {
+			return nil, err
+		}
+		var res *RunResult
+		if parent == "" {
+			res, err = runRoot(ctx, currentGitDir, relPaths, commit, opts)
+		} else {
+			commitOpts := opts
+			commitOpts.Base = parent
+			commitOpts.Target = commit
+			res, err = Run(ctx, dirPaths, commitOpts)
+		}
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, LogEntry{Commit: res.TargetCommit, Subject: subject, Result: res})
+	}
+	return entries, nil
+}
+
+// runRoot diffs the root commit against an empty tree. The commit is read from
+// the git objects because there's no parent to merge it into.
+func runRoot(ctx context.Context, currentGitDir *utils.GitDir, relPaths []string, commit string, opts RunOpts) (*RunResult, error) {
+	if opts.KustomizePath != "" {
+		return nil, errors.Errorf("kustomize path cannot be used to diff the root commit %s", commit)
+	}
+	opts.Progress.emit(ProgressEvent{Type: ProgressEventCloneStarted, Side: "target", Commit: commit})
+	start := time.Now()
+	gitSteps := make([]StepTiming, 0)
+	var targetFSys filesys.FileSystem
+	err := recordStep(&gitSteps, "target").Time("load tree", func() (err error) {
+		targetFSys, err = utils.NewGitTreeFs(ctx, currentGitDir, commit)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	opts.Progress.emit(ProgressEvent{Type: ProgressEventCloneFinished, Side: "target", Commit: commit, Duration: time.Since(start)})
+
+	diffOpts := opts.diffOpts()
+	diffOpts.Dirs = relPaths
+	diffOpts.BaseFileSystem = filesys.MakeFsInMemory()
+	diffOpts.TargetFileSystem = targetFSys
+	diffMap, err := Diff(ctx, filesys.Separator, filesys.Separator, diffOpts)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &RunResult{
+		TargetCommit: commit,
+		Dirs:         relPaths,
+		DiffMap:      diffMap,
+		GitSteps:     gitSteps,
+	}
+	res.emitFinished(opts.Progress)
+	return res, nil
+}
@@ -0,0 +1,113 @@
+/*
+Copyright 2021 Daisuke Taniwaki.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gitkustomizediff
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/dtaniwaki/git-kustomize-diff/pkg/utils"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLog(t *testing.T) {
+	tmpGitDir := makeFixtureRepo(t)
+	defer os.RemoveAll(tmpGitDir)
+	gitDir := utils.NewGitDir(tmpGitDir, "")
+	err := ioutil.WriteFile(filepath.Join(tmpGitDir, "sub2", "pod.yaml"), []byte("apiVersion: v1\nkind: Pod\nmetadata:\n  name: sub2\nspec:\n  containers:\n  - name: sub2\n    image: nginx:1.21\n"), 0644)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	for _, args := range [][]string{{"add", "-A"}, {"commit", "-q", "-m", "pin sub2"}} {
+		_, _, err = gitDir.RunGitCommand(context.Background(), args...)
+		if !assert.NoError(t, err) {
+			t.FailNow()
+		}
+	}
+	cacheDir, err := ioutil.TempDir("", "kustomize-diff-cache-test-")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer os.RemoveAll(cacheDir)
+
+	entries, err := Log(context.Background(), []string{tmpGitDir}, RunOpts{
+		Base:     "main",
+		Target:   "a-branch",
+		InMemory: true,
+		CacheDir: cacheDir,
+	})
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	if !assert.Len(t, entries, 2) {
+		t.FailNow()
+	}
+	assert.Equal(t, "target", entries[0].Subject)
+	assert.Equal(t, "pin sub2", entries[1].Subject)
+	assert.Equal(t, entries[0].Commit, entries[1].Result.BaseCommit)
+	assert.Equal(t, entries[1].Commit, entries[1].Result.TargetCommit)
+	assert.Contains(t, entries[0].Result.DiffMap.Results["sub1"].ToString(), "+    name: sub1-modified")
+	assert.Equal(t, "", entries[0].Result.DiffMap.Results["sub2"].ToString())
+	assert.Equal(t, "", entries[1].Result.DiffMap.Results["sub1"].ToString())
+	assert.Equal(t, []ImageChange{
+		{Resource: "Pod/sub2", Container: "sub2", Old: "nginx:latest", New: "nginx:1.21"},
+	}, entries[1].Result.DiffMap.ImageChanges["sub2"])
+
+	// The builds of the unchanged directories are reused between the commits.
+	files, err := ioutil.ReadDir(cacheDir)
+	if assert.NoError(t, err) {
+		assert.Len(t, files, 4)
+	}
+}
+
+func TestLogRootCommit(t *testing.T) {
+	tmpGitDir := makeFixtureRepo(t)
+	defer os.RemoveAll(tmpGitDir)
+	gitDir := utils.NewGitDir(tmpGitDir, "")
+	for _, args := range [][]string{{"checkout", "-q", "--orphan", "orphan"}, {"commit", "-q", "-m", "root"}} {
+		_, _, err := gitDir.RunGitCommand(context.Background(), args...)
+		if !assert.NoError(t, err) {
+			t.FailNow()
+		}
+	}
+
+	entries, err := Log(context.Background(), []string{tmpGitDir}, RunOpts{
+		Base:   "main",
+		Target: "orphan",
+	})
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	if !assert.Len(t, entries, 1) {
+		t.FailNow()
+	}
+	assert.Equal(t, "root", entries[0].Subject)
+	assert.Equal(t, "", entries[0].Result.BaseCommit)
+	assert.Equal(t, entries[0].Commit, entries[0].Result.TargetCommit)
+	assert.Contains(t, entries[0].Result.DiffMap.Results["sub1"].ToString(), "+    name: sub1")
+
+	// The range is resolved to the commits first.
+	_, err = Log(context.Background(), []string{tmpGitDir}, RunOpts{
+		Base:   "--output=" + filepath.Join(tmpGitDir, "out"),
+		Target: "orphan",
+	})
+	assert.Error(t, err)
+	assert.NoFileExists(t, filepath.Join(tmpGitDir, "out"))
+}
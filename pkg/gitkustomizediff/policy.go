@@ -0,0 +1,164 @@
+/*
+Copyright 2021 Daisuke Taniwaki.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gitkustomizediff
+
+import (
+	"fmt"
+	"io/ioutil"
+
+	"github.com/google/cel-go/cel"
+	"github.com/pkg/errors"
+	"sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+// Policy is a rule which every resource of the kinds must satisfy.
+type Policy struct {
+	Name string `yaml:"name"`
+	// Kinds to apply the policy to. Defaults to all the kinds.
+	Kinds []string `yaml:"kinds,omitempty"`
+	// Expression is a CEL expression which returns true if the resource,
+	// bound to `object`, complies with the policy.
+	Expression string `yaml:"expression"`
+	// Message explains the violation. Defaults to the expression.
+	Message string `yaml:"message,omitempty"`
+}
+
+type PolicyFile struct {
+	Policies []Policy `yaml:"policies"`
+}
+
+type PolicyCheckerOpts struct {
+	Policies []Policy
+	// Files are YAML files of policies in the PolicyFile format.
+	Files []string
+}
+
+type PolicyViolation struct {
+	Policy   string
+	Resource string
+	Message  string
+}
+
+func (v PolicyViolation) String() string {
+	return fmt.Sprintf("[%s] %s: %s", v.Policy, v.Resource, v.Message)
+}
+
+// PolicyChecker evaluates policies against resources in-process.
+type PolicyChecker struct {
+	policies []*compiledPolicy
+}
+
+type compiledPolicy struct {
+	Policy
+	program cel.Program
+}
+
+func NewPolicyChecker(opts PolicyCheckerOpts) (*PolicyChecker, error) {
+	policies := append([]Policy{}, opts.Policies...)
+	for _, file := range opts.Files {
+		bs, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		var policyFile PolicyFile
+		err = yaml.Unmarshal(bs, &policyFile)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid policy file %s", file)
+		}
+		policies = append(policies, policyFile.Policies...)
+	}
+	env, err := cel.NewEnv(cel.Variable("object", cel.DynType))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	pc := &PolicyChecker{}
+	for _, policy := range policies {
+		if policy.Name == "" {
+			return nil, fmt.Errorf("policy name is missing: %s", policy.Expression)
+		}
+		ast, issues := env.Compile(policy.Expression)
+		if issues != nil && issues.Err() != nil {
+			return nil, errors.Wrapf(issues.Err(), "invalid expression of policy %s", policy.Name)
+		}
+		program, err := env.Program(ast)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid expression of policy %s", policy.Name)
+		}
+		pc.policies = append(pc.policies, &compiledPolicy{Policy: policy, program: program})
+	}
+	return pc, nil
+}
+
+// Names returns the names of the policies.
+func (pc *PolicyChecker) Names() []string {
+	names := make([]string, 0, len(pc.policies))
+	for _, policy := range pc.policies {
+		names = append(names, policy.Name)
+	}
+	return names
+}
+
+// Check evaluates the policies against the resources in the built YAML.
+// A policy failing to evaluate on a resource is reported as a violation.
+func (pc *PolicyChecker) Check(s string) ([]PolicyViolation, error) {
+	resources, err := parseResources(s)
+	if err != nil {
+		return nil, err
+	}
+	violations := make([]PolicyViolation, 0)
+	for _, rn := range resources {
+		data, err := resourceData(rn)
+		if err != nil {
+			return nil, err
+		}
+		for _, policy := range pc.policies {
+			if !policy.appliesTo(rn.GetKind()) {
+				continue
+			}
+			message := policy.Message
+			if message == "" {
+				message = policy.Expression
+			}
+			out, _, err := policy.program.Eval(map[string]interface{}{"object": data})
+			if err != nil {
+				message = fmt.Sprintf("failed to evaluate: %s", err)
+			} else if ok, isBool := out.Value().(bool); !isBool {
+				message = fmt.Sprintf("failed to evaluate: expected bool but got %v", out.Type())
+			} else if ok {
+				continue
+			}
+			violations = append(violations, PolicyViolation{
+				Policy:   policy.Name,
+				Resource: resourceID(rn),
+				Message:  message,
+			})
+		}
+	}
+	return violations, nil
+}
+
+func (p *compiledPolicy) appliesTo(kind string) bool {
+	if len(p.Kinds) == 0 {
+		return true
+	}
+	for _, k := range p.Kinds {
+		if k == kind {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,112 @@
+/*
+Copyright 2021 Daisuke Taniwaki.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gitkustomizediff
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPolicyChecker(t *testing.T) {
+	wd, _ := os.Getwd()
+
+	pc, err := NewPolicyChecker(PolicyCheckerOpts{
+		Policies: []Policy{
+			{Name: "has-limits", Kinds: []string{"Deployment"}, Expression: "object.spec.template.spec.containers.all(c, has(c.resources.limits))"},
+		},
+		Files: []string{filepath.Join(wd, "fixtures", "policy", "policies.yaml")},
+	})
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	assert.Equal(t, []string{"has-limits", "no-latest-tag", "no-host-network"}, pc.Names())
+
+	violations, err := pc.Check(strings.TrimLeft(`
+apiVersion: v1
+kind: Pod
+metadata:
+  name: foo
+  namespace: default
+spec:
+  hostNetwork: true
+  containers:
+  - name: foo
+    image: nginx:latest
+---
+apiVersion: v1
+kind: Pod
+metadata:
+  name: bar
+spec:
+  containers:
+  - name: bar
+    image: nginx:1.21
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: foo
+spec:
+  template:
+    spec:
+      containers:
+      - name: foo
+        image: nginx:1.21
+`, "\n"))
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	assert.Equal(t, []PolicyViolation{
+		{Policy: "no-latest-tag", Resource: "Pod/default/foo", Message: "images must not use the latest tag"},
+		{Policy: "no-host-network", Resource: "Pod/default/foo", Message: "hostNetwork must not be enabled"},
+		{Policy: "has-limits", Resource: "Deployment.apps/foo", Message: "failed to evaluate: no such key: resources"},
+	}, violations)
+	assert.Equal(t, "[no-latest-tag] Pod/default/foo: images must not use the latest tag", violations[0].String())
+
+	_, err = NewPolicyChecker(PolicyCheckerOpts{Policies: []Policy{{Name: "invalid", Expression: "object.spec."}}})
+	assert.Error(t, err)
+	_, err = NewPolicyChecker(PolicyCheckerOpts{Policies: []Policy{{Expression: "true"}}})
+	assert.Error(t, err)
+}
+
+func TestDiffPolicy(t *testing.T) {
+	wd, _ := os.Getwd()
+
+	pc, err := NewPolicyChecker(PolicyCheckerOpts{
+		Files: []string{filepath.Join(wd, "fixtures", "policy", "policies.yaml")},
+	})
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	baseDirPath := filepath.Join(wd, "fixtures", "diff", "base")
+	targetDirPath := filepath.Join(wd, "fixtures", "diff", "target")
+	diffMap, err := Diff(context.Background(), baseDirPath, targetDirPath, DiffOpts{PolicyChecker: pc})
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	// sub2 is unchanged and the invalid one fails to build.
+	assert.Equal(t, map[string][]PolicyViolation{
+		"sub1": {
+			{Policy: "no-latest-tag", Resource: "Pod/sub1", Message: "images must not use the latest tag"},
+		},
+	}, diffMap.Violations)
+}
@@ -0,0 +1,96 @@
+/*
+Copyright 2021 Daisuke Taniwaki.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gitkustomizediff
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// ProgressEventType is the type of a progress event.
+type ProgressEventType string
+
+const (
+	// ProgressEventCloneStarted is emitted before a side is cloned or loaded from the git objects.
+	ProgressEventCloneStarted ProgressEventType = "clone started"
+	// ProgressEventCloneFinished is emitted after a side is cloned or loaded from the git objects.
+	ProgressEventCloneFinished ProgressEventType = "clone finished"
+	// ProgressEventBuildStarted is emitted before a directory is built on both sides.
+	ProgressEventBuildStarted ProgressEventType = "build started"
+	// ProgressEventBuildFinished is emitted after a directory is built on both sides.
+	ProgressEventBuildFinished ProgressEventType = "build finished"
+	// ProgressEventDiffComputed is emitted after a directory is built and diffed.
+	ProgressEventDiffComputed ProgressEventType = "diff computed"
+	// ProgressEventRunFinished is emitted with the timings after all the directories are diffed.
+	ProgressEventRunFinished ProgressEventType = "run finished"
+)
+
+// ProgressEvent notifies the progress of a run.
+type ProgressEvent struct {
+	Type ProgressEventType
+	Time time.Time
+	// Side is base or target for the clone events.
+	Side string
+	// Commit is the commit of the clone events, or the target commit of the
+	// run finished events.
+	Commit string
+	// Dir is the directory of the build and diff events.
+	Dir string
+	// Done is the number of the directories processed.
+	Done int
+	// Total is the number of the directories to process.
+	Total int
+	// Duration is the duration of the finished step.
+	Duration time.Duration
+	// Timings is the timings of the finished run.
+	Timings *Timings
+}
+
+// MarshalJSON encodes the event with the duration in seconds.
+func (e ProgressEvent) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Type     ProgressEventType `json:"type"`
+		Time     time.Time         `json:"time"`
+		Side     string            `json:"side,omitempty"`
+		Commit   string            `json:"commit,omitempty"`
+		Dir      string            `json:"dir,omitempty"`
+		Done     int               `json:"done"`
+		Total    int               `json:"total"`
+		Duration float64           `json:"duration,omitempty"`
+		Timings  *Timings          `json:"timings,omitempty"`
+	}{
+		Type:     e.Type,
+		Time:     e.Time,
+		Side:     e.Side,
+		Commit:   e.Commit,
+		Dir:      e.Dir,
+		Done:     e.Done,
+		Total:    e.Total,
+		Duration: e.Duration.Seconds(),
+		Timings:  e.Timings,
+	})
+}
+
+// ProgressFunc receives the progress events.
+type ProgressFunc func(event ProgressEvent)
+
+func (f ProgressFunc) emit(event ProgressEvent) {
+	if f != nil {
+		event.Time = time.Now()
+		f(event)
+	}
+}
@@ -0,0 +1,370 @@
+/*
+Copyright 2021 Daisuke Taniwaki.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gitkustomizediff
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/dtaniwaki/git-kustomize-diff/pkg/utils"
+	"github.com/pkg/errors"
+	"sigs.k8s.io/kustomize/api/konfig"
+	"sigs.k8s.io/kustomize/kyaml/filesys"
+	"sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+// RemoteResourcesMode is how the remote resources of the kustomizations are handled.
+type RemoteResourcesMode string
+
+const (
+	// RemoteResourcesFetch lets kustomize fetch the remote resources on each build.
+	RemoteResourcesFetch RemoteResourcesMode = "fetch"
+	// RemoteResourcesForbid fails the builds with remote resources.
+	RemoteResourcesForbid RemoteResourcesMode = "forbid"
+	// RemoteResourcesMirror resolves the remote bases through a local mirror
+	// keyed by the URL and the ref, which is fetched only if missing.
+	RemoteResourcesMirror RemoteResourcesMode = "mirror"
+)
+
+// ParseRemoteResourcesMode parses the mode. An empty string is RemoteResourcesFetch.
+func ParseRemoteResourcesMode(value string) (RemoteResourcesMode, error) {
+	switch mode := RemoteResourcesMode(value); mode {
+	case "", RemoteResourcesFetch:
+		return RemoteResourcesFetch, nil
+	case RemoteResourcesForbid, RemoteResourcesMirror:
+		return mode, nil
+	}
+	return "", fmt.Errorf("Invalid remote resources mode: %s", value)
+}
+
+// knownGitHosts are the hosts whose repositories are the first two path segments.
+var knownGitHosts = []string{"github.com", "gitlab.com", "bitbucket.org"}
+
+// remoteRef is a remote base in a git repository.
+type remoteRef struct {
+	RepoURL string
+	Ref     string
+	Path    string
+}
+
+// key identifies the checkout of the repository in the mirror.
+func (r remoteRef) key() string {
+	h := sha256.Sum256([]byte(r.RepoURL + "?ref=" + r.Ref))
+	return hex.EncodeToString(h[:])
+}
+
+// isRemoteRef returns true if the reference of a kustomization looks like a URL.
+func isRemoteRef(ref string) bool {
+	if strings.Contains(ref, "://") || strings.HasPrefix(ref, "git@") || strings.HasPrefix(ref, "git::") {
+		return true
+	}
+	for _, host := range knownGitHosts {
+		if strings.HasPrefix(ref, host+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// parseRemoteRef parses a remote base, e.g. github.com/org/repo/path?ref=v1 or
+// https://example.com/repo.git//path?ref=v1. It returns false for the URLs
+// whose repository can't be told, e.g. remote files.
+func parseRemoteRef(ref string) (remoteRef, bool) {
+	ref = strings.TrimPrefix(ref, "git::")
+	r := remoteRef{}
+	if idx := strings.Index(ref, "?"); idx >= 0 {
+		query, err := url.ParseQuery(ref[idx+1:])
+		if err != nil {
+			return r, false
+		}
+		r.Ref = query.Get("ref")
+		if r.Ref == "" {
+			r.Ref = query.Get("version")
+		}
+		ref = ref[:idx]
+	}
+	scheme := ""
+	if idx := strings.Index(ref, "://"); idx >= 0 {
+		scheme, ref = ref[:idx+3], ref[idx+3:]
+	} else if !strings.HasPrefix(ref, "git@") {
+		scheme = "https://"
+	}
+	switch {
+	case strings.Contains(ref, "//"):
+		idx := strings.Index(ref, "//")
+		r.RepoURL, r.Path = ref[:idx], ref[idx+2:]
+	case strings.Contains(ref, ".git/") || strings.HasSuffix(ref, ".git"):
+		idx := strings.Index(ref, ".git")
+		r.RepoURL, r.Path = ref[:idx+4], strings.TrimPrefix(ref[idx+4:], "/")
+	default:
+		segments := strings.Split(ref, "/")
+		known := false
+		for _, host := range knownGitHosts {
+			known = known || segments[0] == host
+		}
+		if !known || len(segments) < 3 {
+			return r, false
+		}
+		r.RepoURL, r.Path = strings.Join(segments[:3], "/"), strings.Join(segments[3:], "/")
+	}
+	r.RepoURL = scheme + r.RepoURL
+	return r, true
+}
+
+// remoteRefs returns the remote references of the kustomization and the
+// kustomizations it refers to by the directories referring them.
+func remoteRefs(fSys filesys.FileSystem, dirPath string) (map[string][]string, error) {
+	refs := make(map[string][]string)
+	visited := make(map[string]bool)
+	queue := []string{filepath.Clean(dirPath)}
+	for len(queue) > 0 {
+		kDir := queue[0]
+		queue = queue[1:]
+		if visited[kDir] || !utils.KustomizationExistsInFs(fSys, kDir) {
+			continue
+		}
+		visited[kDir] = true
+		k, err := utils.ReadKustomization(fSys, kDir)
+		if err != nil {
+			return nil, err
+		}
+		for _, ref := range append(append([]string{}, k.Resources...), k.Components...) {
+			refPath := filepath.Clean(filepath.Join(kDir, ref))
+			if fSys.IsDir(refPath) {
+				queue = append(queue, refPath)
+			} else if !fSys.Exists(refPath) && isRemoteRef(ref) {
+				refs[kDir] = append(refs[kDir], ref)
+			}
+		}
+	}
+	return refs, nil
+}
+
+// checkRemoteResources fails if the kustomization has remote resources.
+func checkRemoteResources(fSys filesys.FileSystem, dirPath string) error {
+	refs, err := remoteRefs(fSys, dirPath)
+	if err != nil {
+		return err
+	}
+	for kDir, kRefs := range refs {
+		return errors.Errorf("remote resource %s in %s is forbidden", kRefs[0], kDir)
+	}
+	return nil
+}
+
+// mirrorRemoteResources fetches the missing remote bases of the kustomization
+// into the mirror and returns the file system resolving them from there.
+func mirrorRemoteResources(ctx context.Context, fSys filesys.FileSystem, dirPath string, opts BuildOpts) (filesys.FileSystem, error) {
+	if opts.RemoteMirrorDir == "" {
+		return nil, errors.New("remote mirror dir is required to mirror the remote resources")
+	}
+	mirrorDir, err := filepath.Abs(opts.RemoteMirrorDir)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	mfs := &mirrorFs{
+		FileSystem: fSys,
+		disk:       filesys.MakeFsOnDisk(),
+		mirrorDir:  mirrorDir,
+		refs:       make(map[string]string),
+	}
+	// The mirrored bases may refer to remote bases as well.
+	queue := []string{dirPath}
+	for len(queue) > 0 {
+		refs, err := remoteRefs(mfs, queue[0])
+		if err != nil {
+			return nil, err
+		}
+		queue = queue[1:]
+		for _, kRefs := range refs {
+			for _, ref := range kRefs {
+				if _, ok := mfs.refs[ref]; ok {
+					continue
+				}
+				r, ok := parseRemoteRef(ref)
+				if !ok {
+					return nil, errors.Errorf("remote resource %s can't be mirrored", ref)
+				}
+				repoDir, err := fetchMirror(ctx, mirrorDir, r, opts)
+				if err != nil {
+					return nil, err
+				}
+				mfs.refs[ref] = filepath.Join(repoDir, filepath.FromSlash(r.Path))
+				queue = append(queue, mfs.refs[ref])
+			}
+		}
+	}
+	return mfs, nil
+}
+
+// fetchMirror checks out the ref of the repository into the mirror if missing
+// and returns the directory of the checkout.
+func fetchMirror(ctx context.Context, mirrorDir string, r remoteRef, opts BuildOpts) (string, error) {
+	logger := loggerOrNop(opts.Logger)
+	// The URL and the ref come from the kustomizations, which must not be
+	// taken as options of git.
+	if strings.HasPrefix(r.RepoURL, "-") || strings.HasPrefix(r.Ref, "-") {
+		return "", errors.Errorf("remote resource %s?ref=%s is invalid", r.RepoURL, r.Ref)
+	}
+	repoDir := filepath.Join(mirrorDir, r.key())
+	if _, err := os.Stat(repoDir); err == nil {
+		return repoDir, nil
+	}
+	logger.Infof("Fetch %s at %s into the mirror", r.RepoURL, r.Ref)
+	err := os.MkdirAll(mirrorDir, 0700)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	tmpDir, err := ioutil.TempDir(mirrorDir, r.key()+"-*.tmp")
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	defer os.RemoveAll(tmpDir)
+	gitDir := utils.NewGitDir(tmpDir, opts.GitPath)
+	ref := r.Ref
+	if ref == "" {
+		ref = "HEAD"
+	}
+	for _, args := range [][]string{
+		{"init", "-q"},
+		{"fetch", "-q", "--depth", "1", "--", r.RepoURL, ref},
+		{"checkout", "-q", "FETCH_HEAD"},
+	} {
+		_, _, err := gitDir.RunGitCommand(ctx, args...)
+		if err != nil {
+			return "", err
+		}
+	}
+	// Only the contents are mirrored.
+	err = os.RemoveAll(filepath.Join(tmpDir, ".git"))
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	err = os.Rename(tmpDir, repoDir)
+	if err != nil {
+		// Another build may have mirrored the same ref.
+		if _, statErr := os.Stat(repoDir); statErr == nil {
+			return repoDir, nil
+		}
+		return "", errors.WithStack(err)
+	}
+	return repoDir, nil
+}
+
+// mirrorFs reads the mirror from the disk and rewrites the remote references
+// of the kustomizations to the mirror.
+type mirrorFs struct {
+	filesys.FileSystem
+	disk      filesys.FileSystem
+	mirrorDir string
+	// refs are the directories in the mirror by the remote references.
+	refs map[string]string
+}
+
+func (mfs *mirrorFs) fs(path string) filesys.FileSystem {
+	path = filepath.Clean(path)
+	if path == mfs.mirrorDir || strings.HasPrefix(path, mfs.mirrorDir+string(filepath.Separator)) {
+		return mfs.disk
+	}
+	return mfs.FileSystem
+}
+
+func (mfs *mirrorFs) Open(path string) (filesys.File, error) {
+	return mfs.fs(path).Open(path)
+}
+
+func (mfs *mirrorFs) IsDir(path string) bool {
+	return mfs.fs(path).IsDir(path)
+}
+
+func (mfs *mirrorFs) ReadDir(path string) ([]string, error) {
+	return mfs.fs(path).ReadDir(path)
+}
+
+func (mfs *mirrorFs) CleanedAbs(path string) (filesys.ConfirmedDir, string, error) {
+	return mfs.fs(path).CleanedAbs(path)
+}
+
+func (mfs *mirrorFs) Exists(path string) bool {
+	return mfs.fs(path).Exists(path)
+}
+
+func (mfs *mirrorFs) Glob(pattern string) ([]string, error) {
+	return mfs.fs(pattern).Glob(pattern)
+}
+
+func (mfs *mirrorFs) Walk(path string, walkFn filepath.WalkFunc) error {
+	return mfs.fs(path).Walk(path, walkFn)
+}
+
+func (mfs *mirrorFs) ReadFile(path string) ([]byte, error) {
+	bs, err := mfs.fs(path).ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range konfig.RecognizedKustomizationFileNames() {
+		if filepath.Base(path) == name {
+			return mfs.rewrite(filepath.Dir(path), bs)
+		}
+	}
+	return bs, nil
+}
+
+// rewrite replaces the remote references of the kustomization in the
+// directory with the relative paths to the mirror since kustomize doesn't
+// accept absolute paths.
+func (mfs *mirrorFs) rewrite(dirPath string, bs []byte) ([]byte, error) {
+	rn, err := yaml.Parse(string(bs))
+	if err != nil {
+		// Leave the error to kustomize.
+		return bs, nil
+	}
+	rewritten := false
+	for _, field := range []string{"resources", "bases", "components"} {
+		list := rn.Field(field)
+		if list == nil || list.Value.YNode().Kind != yaml.SequenceNode {
+			continue
+		}
+		for _, node := range list.Value.YNode().Content {
+			mirrored, ok := mfs.refs[node.Value]
+			if !ok {
+				continue
+			}
+			relPath, err := filepath.Rel(dirPath, mirrored)
+			if err != nil {
+				return nil, errors.WithStack(err)
+			}
+			node.Value = filepath.ToSlash(relPath)
+			rewritten = true
+		}
+	}
+	if !rewritten {
+		return bs, nil
+	}
+	s, err := rn.String()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return []byte(s), nil
+}
@@ -0,0 +1,138 @@
+/*
+Copyright 2021 Daisuke Taniwaki.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gitkustomizediff
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/dtaniwaki/git-kustomize-diff/pkg/utils"
+	"github.com/stretchr/testify/assert"
+	"sigs.k8s.io/kustomize/kyaml/filesys"
+)
+
+func TestParseRemoteRef(t *testing.T) {
+	for ref, expected := range map[string]remoteRef{
+		"github.com/org/repo/path/to/base?ref=v1":         {RepoURL: "https://github.com/org/repo", Ref: "v1", Path: "path/to/base"},
+		"https://github.com/org/repo?version=v2":          {RepoURL: "https://github.com/org/repo", Ref: "v2"},
+		"https://example.com/repo.git/base?ref=main":      {RepoURL: "https://example.com/repo.git", Ref: "main", Path: "base"},
+		"git::https://example.com/org/repo//base?ref=abc": {RepoURL: "https://example.com/org/repo", Ref: "abc", Path: "base"},
+		"git@github.com:org/repo.git/base":                {RepoURL: "git@github.com:org/repo.git", Path: "base"},
+		"ssh://git@example.com/org/repo.git//a/b":         {RepoURL: "ssh://git@example.com/org/repo.git", Path: "a/b"},
+	} {
+		r, ok := parseRemoteRef(ref)
+		if assert.True(t, ok, ref) {
+			assert.Equal(t, expected, r, ref)
+		}
+	}
+	for _, ref := range []string{"https://example.com/manifests/pod.yaml", "github.com/org"} {
+		_, ok := parseRemoteRef(ref)
+		assert.False(t, ok, ref)
+	}
+	assert.True(t, isRemoteRef("github.com/org/repo"))
+	assert.False(t, isRemoteRef("../base"))
+}
+
+func TestBuildRemoteResources(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "kustomize-diff-remote-test-")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer os.RemoveAll(tmpDir)
+	repoDir := filepath.Join(tmpDir, "repo")
+	diskFSys := filesys.MakeFsOnDisk()
+	for path, content := range map[string]string{
+		"base/kustomization.yaml": "resources:\n- pod.yaml\n",
+		"base/pod.yaml":           "apiVersion: v1\nkind: Pod\nmetadata:\n  name: remote\n",
+	} {
+		if !assert.NoError(t, diskFSys.MkdirAll(filepath.Dir(filepath.Join(repoDir, path)))) {
+			t.FailNow()
+		}
+		if !assert.NoError(t, diskFSys.WriteFile(filepath.Join(repoDir, path), []byte(content))) {
+			t.FailNow()
+		}
+	}
+	gitDir := utils.NewGitDir(repoDir, "")
+	_, _, err = gitDir.RunGitCommand(context.Background(), "init", "-q")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	if !assert.NoError(t, gitDir.SetUser(context.Background())) {
+		t.FailNow()
+	}
+	for _, args := range [][]string{{"add", "-A"}, {"commit", "-q", "-m", "init"}, {"tag", "v1"}} {
+		_, _, err := gitDir.RunGitCommand(context.Background(), args...)
+		if !assert.NoError(t, err) {
+			t.FailNow()
+		}
+	}
+
+	fSys := filesys.MakeFsInMemory()
+	err = fSys.WriteFile("/app/overlay/kustomization.yaml", []byte("resources:\n- file://"+repoDir+"//base?ref=v1\nnamePrefix: app-\n"))
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+
+	_, err = Build(context.Background(), "/app/overlay", BuildOpts{FileSystem: fSys, RemoteResources: RemoteResourcesForbid})
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "remote resource file://"+repoDir+"//base?ref=v1 in /app/overlay is forbidden")
+		assert.Equal(t, ErrorCategoryRemoteResource, ClassifyError(err))
+	}
+
+	mirrorDir := filepath.Join(tmpDir, "mirror")
+	opts := BuildOpts{FileSystem: fSys, RemoteResources: RemoteResourcesMirror, RemoteMirrorDir: mirrorDir}
+	yaml, err := Build(context.Background(), "/app/overlay", opts)
+	if assert.NoError(t, err) {
+		assert.Contains(t, yaml, "name: app-remote")
+	}
+	files, err := ioutil.ReadDir(mirrorDir)
+	if assert.NoError(t, err) {
+		assert.Len(t, files, 1)
+	}
+
+	// The mirror is used without fetching the repository again.
+	if !assert.NoError(t, os.RemoveAll(repoDir)) {
+		t.FailNow()
+	}
+	yaml, err = Build(context.Background(), "/app/overlay", opts)
+	if assert.NoError(t, err) {
+		assert.Contains(t, yaml, "name: app-remote")
+	}
+
+	_, err = Build(context.Background(), "/app/overlay", BuildOpts{FileSystem: fSys, RemoteResources: RemoteResourcesMirror})
+	assert.Error(t, err)
+
+	// The configured git is used to fetch.
+	otherMirrorDir := filepath.Join(tmpDir, "other-mirror")
+	_, err = Build(context.Background(), "/app/overlay", BuildOpts{FileSystem: fSys, RemoteResources: RemoteResourcesMirror, RemoteMirrorDir: otherMirrorDir, GitPath: filepath.Join(tmpDir, "missing-git")})
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "missing-git")
+	}
+
+	// The refs aren't taken as options of git.
+	err = fSys.WriteFile("/app/option/kustomization.yaml", []byte("resources:\n- file://"+repoDir+"//base?ref=--upload-pack=touch\n"))
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	_, err = Build(context.Background(), "/app/option", BuildOpts{FileSystem: fSys, RemoteResources: RemoteResourcesMirror, RemoteMirrorDir: otherMirrorDir})
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "is invalid")
+	}
+}
@@ -0,0 +1,70 @@
+/*
+Copyright 2021 Daisuke Taniwaki.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gitkustomizediff
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+	"sigs.k8s.io/kustomize/kyaml/kio"
+	"sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+// parseResources parses the built YAML into resources.
+func parseResources(s string) ([]*yaml.RNode, error) {
+	resources, err := kio.FromBytes([]byte(s))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return resources, nil
+}
+
+// resourceID returns an identifier of the resource for reports, e.g.
+// Deployment.apps/default/nginx. The kinds of the same name in different API
+// groups are distinguished.
+func resourceID(rn *yaml.RNode) string {
+	if namespace := rn.GetNamespace(); namespace != "" {
+		return fmt.Sprintf("%s/%s/%s", groupKind(rn), namespace, rn.GetName())
+	}
+	return fmt.Sprintf("%s/%s", groupKind(rn), rn.GetName())
+}
+
+// groupKind returns the kind of the resource qualified by the API group, e.g.
+// Deployment.apps. The kinds of the core group aren't qualified, e.g. Pod.
+func groupKind(rn *yaml.RNode) string {
+	apiVersion := rn.GetApiVersion()
+	if i := strings.LastIndex(apiVersion, "/"); i >= 0 {
+		return rn.GetKind() + "." + apiVersion[:i]
+	}
+	return rn.GetKind()
+}
+
+// resourceData converts the resource into the generic JSON representation.
+func resourceData(rn *yaml.RNode) (interface{}, error) {
+	bs, err := rn.MarshalJSON()
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	var data interface{}
+	err = json.Unmarshal(bs, &data)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return data, nil
+}
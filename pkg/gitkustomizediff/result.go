@@ -26,8 +26,72 @@ type DiffResult interface {
 	AsMarkdown() string
 }
 
+type BuildStatus string
+
+const (
+	BuildStatusNewlyBroken  BuildStatus = "newly broken"
+	BuildStatusFixed        BuildStatus = "fixed"
+	BuildStatusBrokenOnBoth BuildStatus = "broken on both"
+	BuildStatusTimedOut     BuildStatus = "timed out"
+)
+
+// BuildResult is the result of the build of a directory in either side.
+type BuildResult struct {
+	Yaml string
+	Err  error
+}
+
 type DiffError struct {
-	err error
+	err       error
+	baseErr   error
+	targetErr error
+	category  ErrorCategory
+	// baseCategory and targetCategory are the categories of the build errors.
+	baseCategory   ErrorCategory
+	targetCategory ErrorCategory
+	debug          bool
+	// envPair names the sides by the directories of the environments.
+	envPair *DirPair
+}
+
+func newDiffError(err error, debug bool) *DiffError {
+	return &DiffError{
+		err:      err,
+		category: ClassifyError(err),
+		debug:    debug,
+	}
+}
+
+// newBuildError returns an error of the builds. Either of the errors can be nil.
+// The errors are classified separately, and the category of the result is of
+// the error which isn't a timeout if any.
+func newBuildError(baseErr, targetErr error, debug bool) *DiffError {
+	r := &DiffError{
+		baseErr:   baseErr,
+		targetErr: targetErr,
+		debug:     debug,
+	}
+	if baseErr != nil {
+		r.baseCategory = classifyBuildError(baseErr)
+	}
+	if targetErr != nil {
+		r.targetCategory = classifyBuildError(targetErr)
+	}
+	switch {
+	case baseErr != nil && (r.baseCategory != ErrorCategoryTimeout || targetErr == nil || r.targetCategory == ErrorCategoryTimeout):
+		r.err, r.category = baseErr, r.baseCategory
+	default:
+		r.err, r.category = targetErr, r.targetCategory
+	}
+	return r
+}
+
+// newEnvBuildError returns an error of the builds of a pair of directories,
+// which aren't a change from the base to the target.
+func newEnvBuildError(pair DirPair, baseErr, targetErr error, debug bool) *DiffError {
+	r := newBuildError(baseErr, targetErr, debug)
+	r.envPair = &pair
+	return r
 }
 
 func (r *DiffError) ToString() string {
@@ -35,13 +99,157 @@ func (r *DiffError) ToString() string {
 }
 
 func (r *DiffError) AsMarkdown() string {
-	return fmt.Sprintf("```\n%s\n```", r.Error())
+	if r.envPair != nil {
+		return r.envMarkdown()
+	}
+	var text string
+	switch r.Status() {
+	case BuildStatusNewlyBroken:
+		text = fmt.Sprintf(":boom: Newly broken by this change (%s)", r.category)
+	case BuildStatusFixed:
+		text = fmt.Sprintf(":white_check_mark: Fixed by this change (%s)", r.category)
+	case BuildStatusBrokenOnBoth:
+		text = fmt.Sprintf(":x: Broken on both base and target (%s)", r.category)
+	case BuildStatusTimedOut:
+		text = fmt.Sprintf(":hourglass: Timed out building the %s", sideLabel(r.TimedOutSide(), "base", "target"))
+		if failedSide := r.failedSide(); failedSide != "" {
+			text += fmt.Sprintf("\n\n:x: Failed to build the %s (%s)", failedSide, r.category)
+		}
+	default:
+		return fmt.Sprintf(":x: Failed (%s)\n\n```\n%s\n```", r.category, r.message(r.err))
+	}
+	if r.baseErr != nil {
+		text += fmt.Sprintf("\n\n[base]\n```\n%s\n```", r.message(r.baseErr))
+	}
+	if r.targetErr != nil {
+		text += fmt.Sprintf("\n\n[target]\n```\n%s\n```", r.message(r.targetErr))
+	}
+	return text
+}
+
+func (r *DiffError) envMarkdown() string {
+	base, target := r.envPair.Base, r.envPair.Target
+	var text string
+	switch r.Status() {
+	case BuildStatusNewlyBroken:
+		text = fmt.Sprintf(":boom: Fails only in %s (%s)", target, r.category)
+	case BuildStatusFixed:
+		text = fmt.Sprintf(":boom: Fails only in %s (%s)", base, r.category)
+	case BuildStatusBrokenOnBoth:
+		text = fmt.Sprintf(":x: Fails in both %s and %s (%s)", base, target, r.category)
+	case BuildStatusTimedOut:
+		text = fmt.Sprintf(":hourglass: Timed out building %s", sideLabel(r.TimedOutSide(), base, target))
+		if failedSide := r.failedSide(); failedSide != "" {
+			text += fmt.Sprintf("\n\n:x: Fails in %s (%s)", sideLabel(failedSide, base, target), r.category)
+		}
+	}
+	if r.baseErr != nil {
+		text += fmt.Sprintf("\n\n[%s]\n```\n%s\n```", base, r.message(r.baseErr))
+	}
+	if r.targetErr != nil {
+		text += fmt.Sprintf("\n\n[%s]\n```\n%s\n```", target, r.message(r.targetErr))
+	}
+	return text
+}
+
+// sideLabel returns the name of the side, or both names joined by "and".
+func sideLabel(side, base, target string) string {
+	switch side {
+	case "base":
+		return base
+	case "target":
+		return target
+	default:
+		return fmt.Sprintf("%s and %s", base, target)
+	}
+}
+
+// failedSide returns the side failed without timing out if the other side
+// timed out, otherwise an empty string.
+func (r *DiffError) failedSide() string {
+	switch {
+	case r.baseCategory == ErrorCategoryTimeout && r.targetErr != nil && r.targetCategory != ErrorCategoryTimeout:
+		return "target"
+	case r.targetCategory == ErrorCategoryTimeout && r.baseErr != nil && r.baseCategory != ErrorCategoryTimeout:
+		return "base"
+	default:
+		return ""
+	}
+}
+
+func (r *DiffError) message(err error) string {
+	if r.debug {
+		return DetailedErrorMessage(err)
+	}
+	return ConciseErrorMessage(err)
 }
 
 func (r *DiffError) Error() error {
 	return r.err
 }
 
+func (r *DiffError) BaseError() error {
+	return r.baseErr
+}
+
+func (r *DiffError) TargetError() error {
+	return r.targetErr
+}
+
+func (r *DiffError) Category() ErrorCategory {
+	return r.category
+}
+
+// BaseCategory returns the category of the base build error, otherwise an empty string.
+func (r *DiffError) BaseCategory() ErrorCategory {
+	return r.baseCategory
+}
+
+// TargetCategory returns the category of the target build error, otherwise an empty string.
+func (r *DiffError) TargetCategory() ErrorCategory {
+	return r.targetCategory
+}
+
+// Status returns how this change affects the builds if it's a build error, otherwise an empty string.
+func (r *DiffError) Status() BuildStatus {
+	if r.TimedOutSide() != "" {
+		return BuildStatusTimedOut
+	}
+	switch r.Side() {
+	case "both":
+		return BuildStatusBrokenOnBoth
+	case "base":
+		return BuildStatusFixed
+	case "target":
+		return BuildStatusNewlyBroken
+	default:
+		return ""
+	}
+}
+
+// TimedOutSide returns base, target or both if the builds timed out, otherwise an empty string.
+func (r *DiffError) TimedOutSide() string {
+	return side(r.baseCategory == ErrorCategoryTimeout, r.targetCategory == ErrorCategoryTimeout)
+}
+
+// Side returns base, target or both if it's a build error, otherwise an empty string.
+func (r *DiffError) Side() string {
+	return side(r.baseErr != nil, r.targetErr != nil)
+}
+
+func side(base, target bool) string {
+	switch {
+	case base && target:
+		return "both"
+	case base:
+		return "base"
+	case target:
+		return "target"
+	default:
+		return ""
+	}
+}
+
 type DiffContent struct {
 	content string
 }
@@ -59,14 +267,41 @@ func (r *DiffContent) AsMarkdown() string {
 }
 
 type DiffMap struct {
-	SrcDirs []string
-	DstDirs []string
-	Results map[string]DiffResult
+	SrcDirs      []string
+	DstDirs      []string
+	Results      map[string]DiffResult
+	BaseBuilds   map[string]*BuildResult
+	TargetBuilds map[string]*BuildResult
+	// Validations are the schema validation errors of the target builds.
+	Validations map[string][]string
+	// Violations are the policy violations of the target builds of the changed directories.
+	Violations map[string][]PolicyViolation
+	// Risks are the risky changes of the directories built on both sides.
+	Risks map[string][]Risk
+	// ImmutableFieldChanges are the changes requiring to recreate the resources.
+	ImmutableFieldChanges map[string][]ImmutableFieldChange
+	// ImageChanges are the changes of the container images.
+	ImageChanges map[string][]ImageChange
+	// Timings are the durations of the builds and the diffs.
+	Timings  map[string]KustomizationTiming
+	Warnings []string
+	// Env is true if the directories are pairs of environments rather than
+	// changes from the base to the target.
+	Env bool
 }
 
 func NewDiffMap() *DiffMap {
 	return &DiffMap{
-		Results: make(map[string]DiffResult),
+		Results:               make(map[string]DiffResult),
+		BaseBuilds:            make(map[string]*BuildResult),
+		TargetBuilds:          make(map[string]*BuildResult),
+		Validations:           make(map[string][]string),
+		Violations:            make(map[string][]PolicyViolation),
+		Risks:                 make(map[string][]Risk),
+		ImmutableFieldChanges: make(map[string][]ImmutableFieldChange),
+		ImageChanges:          make(map[string][]ImageChange),
+		Timings:               make(map[string]KustomizationTiming),
+		Warnings:              make([]string, 0),
 	}
 }
 
@@ -80,3 +315,30 @@ func (dm *DiffMap) Dirs() []string {
 	})
 	return paths
 }
+
+// StatusLabel returns the label of the build status, which doesn't refer to a
+// change for the pairs of environments.
+func (dm *DiffMap) StatusLabel(status BuildStatus) string {
+	if dm.Env {
+		switch status {
+		case BuildStatusNewlyBroken:
+			return "fails only in the target directory"
+		case BuildStatusFixed:
+			return "fails only in the base directory"
+		case BuildStatusBrokenOnBoth:
+			return "fails in both directories"
+		}
+	}
+	return string(status)
+}
+
+// DirsByBuildStatus returns the directories with the build status.
+func (dm *DiffMap) DirsByBuildStatus(status BuildStatus) []string {
+	dirs := make([]string, 0)
+	for _, dir := range dm.Dirs() {
+		if r, ok := dm.Results[dir].(*DiffError); ok && r.Status() == status {
+			dirs = append(dirs, dir)
+		}
+	}
+	return dirs
+}
@@ -0,0 +1,148 @@
+/*
+Copyright 2021 Daisuke Taniwaki.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gitkustomizediff
+
+import (
+	"fmt"
+
+	"sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+type RiskCategory string
+
+const (
+	RiskCategoryDeletion        RiskCategory = "deletion"
+	RiskCategoryRename          RiskCategory = "rename"
+	RiskCategorySelectorChange  RiskCategory = "selector change"
+	RiskCategoryNamespaceChange RiskCategory = "namespace change"
+)
+
+// DefaultRiskyKinds are the kinds whose deletion loses data or other resources.
+var DefaultRiskyKinds = []string{
+	"CustomResourceDefinition",
+	"Namespace",
+	"PersistentVolume",
+	"PersistentVolumeClaim",
+}
+
+// selectorFields are the selectors of the workload kinds, which can't be
+// changed without recreating the workloads.
+var selectorFields = []ImmutableField{
+	{Kind: "DaemonSet.apps", Path: []string{"spec", "selector"}},
+	{Kind: "Deployment.apps", Path: []string{"spec", "selector"}},
+	{Kind: "ReplicaSet.apps", Path: []string{"spec", "selector"}},
+	{Kind: "StatefulSet.apps", Path: []string{"spec", "selector"}},
+}
+
+type Risk struct {
+	Category RiskCategory
+	Resource string
+	Message  string
+}
+
+func (r Risk) String() string {
+	return fmt.Sprintf("%s: %s", r.Resource, r.Message)
+}
+
+// ClassifyRisks returns the risky changes from the base build to the target build.
+// A deleted resource of the risky kinds is regarded as renamed if a new
+// resource of the same kind is added in the same namespace.
+func ClassifyRisks(baseYaml, targetYaml string, riskyKinds []string) ([]Risk, error) {
+	baseResources, err := parseResources(baseYaml)
+	if err != nil {
+		return nil, err
+	}
+	targetResources, err := parseResources(targetYaml)
+	if err != nil {
+		return nil, err
+	}
+	isRiskyKind := make(map[string]bool, len(riskyKinds))
+	for _, kind := range riskyKinds {
+		isRiskyKind[kind] = true
+	}
+	baseIDs := make(map[string]bool, len(baseResources))
+	for _, rn := range baseResources {
+		baseIDs[resourceID(rn)] = true
+	}
+	targetByID := make(map[string]*yaml.RNode, len(targetResources))
+	added := make([]*yaml.RNode, 0)
+	for _, rn := range targetResources {
+		id := resourceID(rn)
+		targetByID[id] = rn
+		if !baseIDs[id] {
+			added = append(added, rn)
+		}
+	}
+	// takeAdded takes the first added resource matching the condition.
+	takeAdded := func(match func(rn *yaml.RNode) bool) *yaml.RNode {
+		for i, rn := range added {
+			if match(rn) {
+				added = append(added[:i], added[i+1:]...)
+				return rn
+			}
+		}
+		return nil
+	}
+
+	risks := make([]Risk, 0)
+	for _, baseRn := range baseResources {
+		id := resourceID(baseRn)
+		if targetRn, ok := targetByID[id]; ok {
+			changed, err := changedFields(baseRn, targetRn, selectorFields)
+			if err != nil {
+				return nil, err
+			}
+			if len(changed) > 0 {
+				risks = append(risks, Risk{
+					Category: RiskCategorySelectorChange,
+					Resource: id,
+					Message:  "spec.selector is changed, which requires to recreate the resource",
+				})
+			}
+			continue
+		}
+		if moved := takeAdded(func(rn *yaml.RNode) bool {
+			return groupKind(rn) == groupKind(baseRn) && rn.GetName() == baseRn.GetName()
+		}); moved != nil {
+			risks = append(risks, Risk{
+				Category: RiskCategoryNamespaceChange,
+				Resource: id,
+				Message:  fmt.Sprintf("namespace is changed from %q to %q", baseRn.GetNamespace(), moved.GetNamespace()),
+			})
+			continue
+		}
+		if !isRiskyKind[baseRn.GetKind()] {
+			continue
+		}
+		if renamed := takeAdded(func(rn *yaml.RNode) bool {
+			return groupKind(rn) == groupKind(baseRn) && rn.GetNamespace() == baseRn.GetNamespace()
+		}); renamed != nil {
+			risks = append(risks, Risk{
+				Category: RiskCategoryRename,
+				Resource: id,
+				Message:  fmt.Sprintf("renamed to %s, which deletes the resource", resourceID(renamed)),
+			})
+			continue
+		}
+		risks = append(risks, Risk{
+			Category: RiskCategoryDeletion,
+			Resource: id,
+			Message:  "deleted",
+		})
+	}
+	return risks, nil
+}
@@ -0,0 +1,116 @@
+/*
+Copyright 2021 Daisuke Taniwaki.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gitkustomizediff
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClassifyRisks(t *testing.T) {
+	baseYaml := strings.TrimLeft(`
+apiVersion: v1
+kind: Namespace
+metadata:
+  name: foo
+---
+apiVersion: v1
+kind: PersistentVolumeClaim
+metadata:
+  name: data
+  namespace: foo
+---
+apiVersion: v1
+kind: PersistentVolumeClaim
+metadata:
+  name: cache
+  namespace: foo
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: config
+  namespace: foo
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: web
+  namespace: foo
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+  namespace: foo
+spec:
+  selector:
+    matchLabels:
+      app: web
+`, "\n")
+	targetYaml := strings.TrimLeft(`
+apiVersion: v1
+kind: PersistentVolumeClaim
+metadata:
+  name: data-v2
+  namespace: foo
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: web
+  namespace: bar
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+  namespace: foo
+spec:
+  selector:
+    matchLabels:
+      app: web
+      tier: frontend
+`, "\n")
+
+	risks, err := ClassifyRisks(baseYaml, targetYaml, DefaultRiskyKinds)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	assert.Equal(t, []Risk{
+		{Category: RiskCategoryDeletion, Resource: "Namespace/foo", Message: "deleted"},
+		{Category: RiskCategoryRename, Resource: "PersistentVolumeClaim/foo/data", Message: "renamed to PersistentVolumeClaim/foo/data-v2, which deletes the resource"},
+		{Category: RiskCategoryDeletion, Resource: "PersistentVolumeClaim/foo/cache", Message: "deleted"},
+		{Category: RiskCategoryNamespaceChange, Resource: "Service/foo/web", Message: "namespace is changed from \"foo\" to \"bar\""},
+		{Category: RiskCategorySelectorChange, Resource: "Deployment.apps/foo/web", Message: "spec.selector is changed, which requires to recreate the resource"},
+	}, risks)
+	assert.Equal(t, "Namespace/foo: deleted", risks[0].String())
+
+	risks, err = ClassifyRisks(baseYaml, baseYaml, DefaultRiskyKinds)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	assert.Empty(t, risks)
+
+	risks, err = ClassifyRisks(baseYaml, "", []string{})
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	assert.Empty(t, risks)
+}
@@ -17,119 +17,359 @@ limitations under the License.
 package gitkustomizediff
 
 import (
+	"context"
 	"io/ioutil"
 	"os"
 	"regexp"
+	"time"
 
 	"github.com/dtaniwaki/git-kustomize-diff/pkg/utils"
 	"github.com/pkg/errors"
-	log "github.com/sirupsen/logrus"
+	"sigs.k8s.io/kustomize/api/types"
+	"sigs.k8s.io/kustomize/kyaml/filesys"
 )
 
 type RunOpts struct {
-	Base          string
-	Target        string
-	IncludeRegexp *regexp.Regexp
-	ExcludeRegexp *regexp.Regexp
-	KustomizePath string
-	GitPath       string
-	Debug         bool
-	AllowDirty    bool
+	Base               string
+	Target             string
+	IncludeRegexp      *regexp.Regexp
+	ExcludeRegexp      *regexp.Regexp
+	LeafOnly           bool
+	KustomizePath      string
+	LoadRestrictions   types.LoadRestrictions
+	EnableHelm         bool
+	HelmCommand        string
+	EnableAlphaPlugins bool
+	EnableExec         bool
+	HelmValuesFile     string
+	Builders           []Builder
+	Validator          *Validator
+	PolicyChecker      *PolicyChecker
+	RiskyKinds         []string
+	ImmutableFields    []ImmutableField
+	CacheDir           string
+	RemoteResources    RemoteResourcesMode
+	RemoteMirrorDir    string
+	BuildTimeout       time.Duration
+	GitPath            string
+	Debug              bool
+	AllowDirty         bool
+	// InMemory builds the kustomizations directly from the git objects
+	// instead of cloning the repository.
+	InMemory bool
+	// SkipSubmodules doesn't check out the submodules in the clones. The
+	// in-memory mode reads the submodules initialized in the work tree.
+	SkipSubmodules bool
+	// Logger defaults to discard the logs.
+	Logger Logger
+	// Progress receives the progress events if set.
+	Progress ProgressFunc
 }
 
 type RunResult struct {
+	// BaseCommit is empty for a root commit diffed by Log.
 	BaseCommit   string
 	TargetCommit string
-	DiffMap      *DiffMap
+	// Dirs are the diffed directories relative to the repository root.
+	Dirs    []string
+	DiffMap *DiffMap
+	// GitSteps are the durations of the git steps preparing both sides.
+	GitSteps []StepTiming
+	// SubmoduleChanges are the changes of the submodule commits in the target.
+	SubmoduleChanges []utils.SubmoduleChange
 }
 
-func Run(dirPath string, opts RunOpts) (*RunResult, error) {
-	log.Info("Start run")
-	currentGitDir := utils.NewGitDir(dirPath, opts.GitPath)
-	baseCommitish := opts.Base
-	if baseCommitish == "" {
-		baseCommitish = "origin/main"
+func (opts RunOpts) diffOpts() DiffOpts {
+	return DiffOpts{
+		IncludeRegexp:      opts.IncludeRegexp,
+		ExcludeRegexp:      opts.ExcludeRegexp,
+		LeafOnly:           opts.LeafOnly,
+		KustomizePath:      opts.KustomizePath,
+		LoadRestrictions:   opts.LoadRestrictions,
+		EnableHelm:         opts.EnableHelm,
+		HelmCommand:        opts.HelmCommand,
+		EnableAlphaPlugins: opts.EnableAlphaPlugins,
+		EnableExec:         opts.EnableExec,
+		HelmValuesFile:     opts.HelmValuesFile,
+		Builders:           opts.Builders,
+		Debug:              opts.Debug,
+		Validator:          opts.Validator,
+		PolicyChecker:      opts.PolicyChecker,
+		RiskyKinds:         opts.RiskyKinds,
+		ImmutableFields:    opts.ImmutableFields,
+		CacheDir:           opts.CacheDir,
+		RemoteResources:    opts.RemoteResources,
+		RemoteMirrorDir:    opts.RemoteMirrorDir,
+		GitPath:            opts.GitPath,
+		BuildTimeout:       opts.BuildTimeout,
+		Logger:             opts.Logger,
+		Progress:           opts.Progress,
 	}
-	baseCommit, err := currentGitDir.CommitHash(baseCommitish)
+}
+
+// Run diffs the kustomizations in the directories of a repository between the
+// base and the target. The current directory is used if no directory is given.
+func Run(ctx context.Context, dirPaths []string, opts RunOpts) (*RunResult, error) {
+	logger := loggerOrNop(opts.Logger)
+	logger.Infof("Start run")
+	currentGitDir, relPaths, err := repoDirs(ctx, dirPaths, opts.GitPath)
 	if err != nil {
 		return nil, err
 	}
-	targetCommitish := opts.Target
-	if targetCommitish == "" {
-		targetCommitish, err = currentGitDir.CurrentBranch()
-		if err != nil {
-			return nil, err
-		}
+	baseCommitish, targetCommitish, err := commitishes(ctx, currentGitDir, opts)
+	if err != nil {
+		return nil, err
+	}
+	baseCommit, err := currentGitDir.CommitHash(ctx, baseCommitish)
+	if err != nil {
+		return nil, err
 	}
-	targetCommit, err := currentGitDir.CommitHash(targetCommitish)
+	targetCommit, err := currentGitDir.CommitHash(ctx, targetCommitish)
 	if err != nil {
 		return nil, err
 	}
 
+	submoduleChanges, err := currentGitDir.SubmoduleChanges(ctx, baseCommit, targetCommit)
+	if err != nil {
+		return nil, err
+	}
+	if len(submoduleChanges) > 0 {
+		logger.Infof("Found %d submodule change(s)", len(submoduleChanges))
+	}
+
 	dirtyPatch := ""
 	if opts.AllowDirty {
-		log.Infof("Generate a dirty patch from %s", targetCommit)
-		diff, err := currentGitDir.Diff(targetCommit)
+		logger.Infof("Generate a dirty patch from %s", targetCommit)
+		diff, err := currentGitDir.Diff(ctx, targetCommit)
 		if err != nil {
 			return nil, err
 		}
 		dirtyPatch = diff
 	}
 
-	log.Infof("Clone the git repo at %s for base", baseCommit)
+	if opts.InMemory {
+		res, err := runInMemory(ctx, currentGitDir, relPaths, baseCommit, targetCommit, dirtyPatch, opts)
+		if err != nil {
+			return nil, err
+		}
+		res.SubmoduleChanges = submoduleChanges
+		return res, nil
+	}
+
+	logger.Infof("Clone the git repo at %s for base", baseCommit)
 	baseDirPath, err := ioutil.TempDir("", "git-kustomize-diff-base-")
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
 	if opts.Debug {
-		log.Infof("Base repo path: %s", baseDirPath)
+		logger.Infof("Base repo path: %s", baseDirPath)
 	} else {
 		defer os.RemoveAll(baseDirPath)
 	}
-	baseGitDir, err := currentGitDir.CloneAndCheckout(baseDirPath, baseCommit)
+	opts.Progress.emit(ProgressEvent{Type: ProgressEventCloneStarted, Side: "base", Commit: baseCommit})
+	start := time.Now()
+	gitSteps := make([]StepTiming, 0)
+	baseTimer := recordStep(&gitSteps, "base")
+	baseGitDir, err := currentGitDir.CloneAndCheckout(ctx, baseDirPath, baseCommit, baseTimer)
 	if err != nil {
 		return nil, err
 	}
+	if !opts.SkipSubmodules {
+		err = baseTimer.Time("submodules", func() error {
+			return baseGitDir.UpdateSubmodules(ctx, currentGitDir)
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	opts.Progress.emit(ProgressEvent{Type: ProgressEventCloneFinished, Side: "base", Commit: baseCommit, Duration: time.Since(start)})
 
-	log.Infof("Clone the git repo at %s for target", baseCommit)
+	logger.Infof("Clone the git repo at %s for target", baseCommit)
 	targetDirPath, err := ioutil.TempDir("", "git-kustomize-diff-target-")
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
 	if opts.Debug {
-		log.Infof("Target repo path: %s", targetDirPath)
+		logger.Infof("Target repo path: %s", targetDirPath)
 	} else {
 		defer os.RemoveAll(targetDirPath)
 	}
-	targetGitDir, err := currentGitDir.CloneAndCheckout(targetDirPath, baseCommit)
+	opts.Progress.emit(ProgressEvent{Type: ProgressEventCloneStarted, Side: "target", Commit: targetCommit})
+	start = time.Now()
+	targetTimer := recordStep(&gitSteps, "target")
+	targetGitDir, err := currentGitDir.CloneAndCheckout(ctx, targetDirPath, baseCommit, targetTimer)
 	if err != nil {
 		return nil, err
 	}
-	log.Infof("Merge the commit at %s into the target repo", targetCommit)
-	err = targetGitDir.Merge(targetCommit)
+	logger.Infof("Merge the commit at %s into the target repo", targetCommit)
+	err = targetTimer.Time("merge", func() error {
+		return targetGitDir.Merge(ctx, targetCommit)
+	})
 	if err != nil {
 		return nil, err
 	}
 	if dirtyPatch != "" {
-		log.Infof("Apply the dirty patch")
-		err = targetGitDir.Apply(dirtyPatch)
+		logger.Infof("Apply the dirty patch")
+		err = targetTimer.Time("apply", func() error {
+			return targetGitDir.Apply(ctx, dirtyPatch)
+		})
 		if err != nil {
 			return nil, err
 		}
 	}
+	if !opts.SkipSubmodules {
+		err = targetTimer.Time("submodules", func() error {
+			return targetGitDir.UpdateSubmodules(ctx, currentGitDir)
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	opts.Progress.emit(ProgressEvent{Type: ProgressEventCloneFinished, Side: "target", Commit: targetCommit, Duration: time.Since(start)})
+
+	// The kustomizations are keyed by the paths from the repository root.
+	diffOpts := opts.diffOpts()
+	diffOpts.Dirs = relPaths
+	diffMap, err := Diff(ctx, baseDirPath, targetDirPath, diffOpts)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &RunResult{
+		BaseCommit:       baseCommit,
+		TargetCommit:     targetCommit,
+		Dirs:             relPaths,
+		DiffMap:          diffMap,
+		GitSteps:         gitSteps,
+		SubmoduleChanges: submoduleChanges,
+	}
+	res.emitFinished(opts.Progress)
+	return res, nil
+}
+
+// repoDirs returns the repository of the directories and the directories
+// relative to the repository root.
+func repoDirs(ctx context.Context, dirPaths []string, gitPath string) (*utils.GitDir, []string, error) {
+	if len(dirPaths) == 0 {
+		dirPaths = []string{"."}
+	}
+	gitDir := utils.NewGitDir(dirPaths[0], gitPath)
+	rootDir, err := gitDir.GetRootDir(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	relPaths := make([]string, 0, len(dirPaths))
+	for _, dirPath := range dirPaths {
+		dirGitDir := utils.NewGitDir(dirPath, gitPath)
+		dirRootDir, err := dirGitDir.GetRootDir(ctx)
+		if err != nil {
+			return nil, nil, err
+		}
+		if dirRootDir != rootDir {
+			return nil, nil, errors.Errorf("%s is not in the repository at %s", dirPath, rootDir)
+		}
+		relPath, err := dirGitDir.RelPath(ctx)
+		if err != nil {
+			return nil, nil, err
+		}
+		relPaths = append(relPaths, relPath)
+	}
+	return gitDir, relPaths, nil
+}
+
+// commitishes returns the base and the target with the defaults.
+func commitishes(ctx context.Context, gitDir *utils.GitDir, opts RunOpts) (string, string, error) {
+	base := opts.Base
+	if base == "" {
+		base = "origin/main"
+	}
+	target := opts.Target
+	if target == "" {
+		var err error
+		target, err = gitDir.CurrentBranch(ctx)
+		if err != nil {
+			return "", "", err
+		}
+	}
+	return base, target, nil
+}
+
+func runInMemory(ctx context.Context, currentGitDir *utils.GitDir, relPaths []string, baseCommit, targetCommit, dirtyPatch string, opts RunOpts) (*RunResult, error) {
+	if opts.KustomizePath != "" {
+		return nil, errors.New("kustomize path cannot be used with the in-memory mode")
+	}
+	gitVersion, err := currentGitDir.Version(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if !utils.VersionAtLeast(gitVersion, utils.MergeTreeMinGitVersion) {
+		return nil, errors.Errorf("the in-memory mode requires git %s or later for merge-tree --write-tree, but git is %s; disable InMemory of the run options to clone the repo instead", utils.MergeTreeMinGitVersion, gitVersion)
+	}
+	logger := loggerOrNop(opts.Logger)
 
-	diffMap, err := Diff(baseGitDir.WorkDir.Dir, targetGitDir.WorkDir.Dir, DiffOpts{
-		IncludeRegexp: opts.IncludeRegexp,
-		ExcludeRegexp: opts.ExcludeRegexp,
-		KustomizePath: opts.KustomizePath,
+	logger.Infof("Load the git tree at %s for base", baseCommit)
+	opts.Progress.emit(ProgressEvent{Type: ProgressEventCloneStarted, Side: "base", Commit: baseCommit})
+	start := time.Now()
+	gitSteps := make([]StepTiming, 0)
+	var baseFSys filesys.FileSystem
+	err = recordStep(&gitSteps, "base").Time("load tree", func() (err error) {
+		baseFSys, err = utils.NewGitTreeFs(ctx, currentGitDir, baseCommit)
+		return err
 	})
 	if err != nil {
 		return nil, err
 	}
+	opts.Progress.emit(ProgressEvent{Type: ProgressEventCloneFinished, Side: "base", Commit: baseCommit, Duration: time.Since(start)})
 
-	return &RunResult{
+	logger.Infof("Merge the commit at %s into the tree at %s for target", targetCommit, baseCommit)
+	opts.Progress.emit(ProgressEvent{Type: ProgressEventCloneStarted, Side: "target", Commit: targetCommit})
+	start = time.Now()
+	targetTimer := recordStep(&gitSteps, "target")
+	var targetTree string
+	err = targetTimer.Time("merge tree", func() (err error) {
+		targetTree, err = currentGitDir.MergeTree(ctx, baseCommit, targetCommit)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if dirtyPatch != "" {
+		logger.Infof("Apply the dirty patch")
+		err = targetTimer.Time("apply", func() (err error) {
+			targetTree, err = currentGitDir.ApplyToTree(ctx, targetTree, dirtyPatch)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	logger.Infof("Load the git tree at %s for target", targetTree)
+	var targetFSys filesys.FileSystem
+	err = targetTimer.Time("load tree", func() (err error) {
+		targetFSys, err = utils.NewGitTreeFs(ctx, currentGitDir, targetTree)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	opts.Progress.emit(ProgressEvent{Type: ProgressEventCloneFinished, Side: "target", Commit: targetCommit, Duration: time.Since(start)})
+
+	diffOpts := opts.diffOpts()
+	diffOpts.Dirs = relPaths
+	diffOpts.BaseFileSystem = baseFSys
+	diffOpts.TargetFileSystem = targetFSys
+	diffMap, err := Diff(ctx, filesys.Separator, filesys.Separator, diffOpts)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &RunResult{
 		BaseCommit:   baseCommit,
 		TargetCommit: targetCommit,
+		Dirs:         relPaths,
 		DiffMap:      diffMap,
-	}, nil
+		GitSteps:     gitSteps,
+	}
+	res.emitFinished(opts.Progress)
+	return res, nil
 }
@@ -17,8 +17,10 @@ limitations under the License.
 package gitkustomizediff
 
 import (
+	"context"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 
@@ -47,19 +49,255 @@ func TestRun(t *testing.T) {
 	workDir := &utils.WorkDir{
 		Dir: tmpGitDir,
 	}
-	_, _, err = workDir.RunCommand("git", "clone", exampleRepoUrl, tmpGitDir)
+	_, _, err = workDir.RunCommand(context.Background(), "git", "clone", exampleRepoUrl, tmpGitDir)
 	if !assert.NoError(t, err) {
 		t.FailNow()
 	}
-	res, err := Run(tmpGitDir, RunOpts{
+	res, err := Run(context.Background(), []string{tmpGitDir}, RunOpts{
 		Base:   "origin/main",
 		Target: "origin/a-branch",
 	})
 	if !assert.NoError(t, err) {
 		t.FailNow()
 	}
-	assert.Equal(t, "6206e0c", res.BaseCommit)
-	assert.Equal(t, "5a1c160", res.TargetCommit)
+	assert.Len(t, res.BaseCommit, 40)
+	assert.Equal(t, "6206e0c", utils.ShortHash(res.BaseCommit))
+	assert.Len(t, res.TargetCommit, 40)
+	assert.Equal(t, "5a1c160", utils.ShortHash(res.TargetCommit))
 	assert.Equal(t, []string{"foo"}, res.DiffMap.Dirs())
 	assert.Equal(t, expectedFooDiff, res.DiffMap.Results["foo"].ToString())
 }
+
+func copyFixtureDir(t *testing.T, srcDirPath, dstDirPath string) {
+	err := filepath.Walk(srcDirPath, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		relPath, err := filepath.Rel(srcDirPath, path)
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return os.MkdirAll(filepath.Join(dstDirPath, relPath), 0700)
+		}
+		bs, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		return ioutil.WriteFile(filepath.Join(dstDirPath, relPath), bs, 0600)
+	})
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+}
+
+// makeFixtureRepo makes a git repo whose main branch has the base fixtures
+// and whose a-branch has the target fixtures.
+func makeFixtureRepo(t *testing.T) string {
+	wd, _ := os.Getwd()
+	tmpGitDir, err := ioutil.TempDir("", "kustomize-diff-test-")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	gitDir := utils.NewGitDir(tmpGitDir, "")
+	run := func(args ...string) {
+		_, _, err := gitDir.RunGitCommand(context.Background(), args...)
+		if !assert.NoError(t, err) {
+			t.FailNow()
+		}
+	}
+	run("init", "-q")
+	if !assert.NoError(t, gitDir.SetUser(context.Background())) {
+		t.FailNow()
+	}
+	run("checkout", "-q", "-b", "main")
+	copyFixtureDir(t, filepath.Join(wd, "fixtures", "diff", "base"), tmpGitDir)
+	run("add", "-A")
+	run("commit", "-q", "-m", "base")
+	run("checkout", "-q", "-b", "a-branch")
+	copyFixtureDir(t, filepath.Join(wd, "fixtures", "diff", "target"), tmpGitDir)
+	run("add", "-A")
+	run("commit", "-q", "-m", "target")
+	return tmpGitDir
+}
+
+func TestRunInMemory(t *testing.T) {
+	tmpGitDir := makeFixtureRepo(t)
+	defer os.RemoveAll(tmpGitDir)
+
+	expectedRes, err := Run(context.Background(), []string{tmpGitDir}, RunOpts{
+		Base:   "main",
+		Target: "a-branch",
+	})
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	cloneEvents := make([]ProgressEvent, 0)
+	res, err := Run(context.Background(), []string{tmpGitDir}, RunOpts{
+		Base:     "main",
+		Target:   "a-branch",
+		InMemory: true,
+		Progress: func(event ProgressEvent) {
+			if event.Side != "" {
+				cloneEvents = append(cloneEvents, ProgressEvent{Type: event.Type, Side: event.Side, Commit: event.Commit})
+			}
+		},
+	})
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	assert.Equal(t, expectedRes.BaseCommit, res.BaseCommit)
+	assert.Equal(t, expectedRes.TargetCommit, res.TargetCommit)
+	assert.Equal(t, []string{"invalid", "sub1", "sub2"}, res.DiffMap.Dirs())
+	for _, dir := range []string{"sub1", "sub2"} {
+		assert.Equal(t, expectedRes.DiffMap.Results[dir].ToString(), res.DiffMap.Results[dir].ToString())
+	}
+	assert.IsType(t, &DiffError{}, res.DiffMap.Results["invalid"])
+	assert.Equal(t, []ProgressEvent{
+		{Type: ProgressEventCloneStarted, Side: "base", Commit: res.BaseCommit},
+		{Type: ProgressEventCloneFinished, Side: "base", Commit: res.BaseCommit},
+		{Type: ProgressEventCloneStarted, Side: "target", Commit: res.TargetCommit},
+		{Type: ProgressEventCloneFinished, Side: "target", Commit: res.TargetCommit},
+	}, cloneEvents)
+
+	// merge-tree --write-tree is missing in the old versions of git.
+	tmpBinDir, err := ioutil.TempDir("", "git-kustomize-diff-")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer os.RemoveAll(tmpBinDir)
+	oldGitPath := filepath.Join(tmpBinDir, "git")
+	err = ioutil.WriteFile(oldGitPath, []byte("#!/bin/sh\nif [ \"$1\" = version ]; then echo 'git version 2.37.1'; else exec git \"$@\"; fi\n"), 0755)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	_, err = Run(context.Background(), []string{tmpGitDir}, RunOpts{
+		Base:     "main",
+		Target:   "a-branch",
+		InMemory: true,
+		GitPath:  oldGitPath,
+	})
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "requires git 2.38 or later")
+		assert.Contains(t, err.Error(), "but git is 2.37.1")
+	}
+}
+
+func TestRunDirs(t *testing.T) {
+	tmpGitDir := makeFixtureRepo(t)
+	defer os.RemoveAll(tmpGitDir)
+
+	dirs := []string{filepath.Join(tmpGitDir, "sub2"), filepath.Join(tmpGitDir, "sub1")}
+	for _, inMemory := range []bool{false, true} {
+		res, err := Run(context.Background(), dirs, RunOpts{
+			Base:     "main",
+			Target:   "a-branch",
+			InMemory: inMemory,
+		})
+		if !assert.NoError(t, err) {
+			t.FailNow()
+		}
+		assert.Equal(t, []string{"sub2", "sub1"}, res.Dirs)
+		assert.Equal(t, []string{"sub1", "sub2"}, res.DiffMap.Dirs())
+	}
+
+	otherGitDir := makeFixtureRepo(t)
+	defer os.RemoveAll(otherGitDir)
+	_, err := Run(context.Background(), []string{tmpGitDir, otherGitDir}, RunOpts{
+		Base:   "main",
+		Target: "a-branch",
+	})
+	assert.Error(t, err)
+}
+
+func TestRunSubmodules(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "kustomize-diff-test-")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer os.RemoveAll(tmpDir)
+	writeFile := func(path, content string) {
+		if !assert.NoError(t, os.MkdirAll(filepath.Dir(path), 0700)) {
+			t.FailNow()
+		}
+		if !assert.NoError(t, ioutil.WriteFile(path, []byte(content), 0600)) {
+			t.FailNow()
+		}
+	}
+	git := func(gitDir *utils.GitDir, args ...string) {
+		_, _, err := gitDir.RunGitCommand(context.Background(), args...)
+		if !assert.NoError(t, err) {
+			t.FailNow()
+		}
+	}
+	pod := "apiVersion: v1\nkind: Pod\nmetadata:\n  name: lib\nspec:\n  containers:\n  - name: lib\n    image: %s\n"
+
+	libDir := utils.NewGitDir(filepath.Join(tmpDir, "lib"), "")
+	writeFile(filepath.Join(libDir.WorkDir.Dir, "base", "kustomization.yaml"), "resources:\n- pod.yaml\n")
+	writeFile(filepath.Join(libDir.WorkDir.Dir, "base", "pod.yaml"), strings.Replace(pod, "%s", "nginx:1.20", 1))
+	git(libDir, "init", "-q")
+	if !assert.NoError(t, libDir.SetUser(context.Background())) {
+		t.FailNow()
+	}
+	git(libDir, "add", "-A")
+	git(libDir, "commit", "-q", "-m", "lib")
+
+	repoDir := utils.NewGitDir(filepath.Join(tmpDir, "repo"), "")
+	writeFile(filepath.Join(repoDir.WorkDir.Dir, "overlay", "kustomization.yaml"), "resources:\n- ../lib/base\n")
+	git(repoDir, "init", "-q")
+	if !assert.NoError(t, repoDir.SetUser(context.Background())) {
+		t.FailNow()
+	}
+	git(repoDir, "checkout", "-q", "-b", "main")
+	git(repoDir, "-c", "protocol.file.allow=always", "submodule", "add", "-q", libDir.WorkDir.Dir, "lib")
+	git(repoDir, "add", "-A")
+	git(repoDir, "commit", "-q", "-m", "base")
+	git(repoDir, "checkout", "-q", "-b", "a-branch")
+	subDir := utils.NewGitDir(filepath.Join(repoDir.WorkDir.Dir, "lib"), "")
+	if !assert.NoError(t, subDir.SetUser(context.Background())) {
+		t.FailNow()
+	}
+	writeFile(filepath.Join(subDir.WorkDir.Dir, "base", "pod.yaml"), strings.Replace(pod, "%s", "nginx:1.21", 1))
+	git(subDir, "commit", "-q", "-a", "-m", "update lib")
+	git(repoDir, "add", "-A")
+	git(repoDir, "commit", "-q", "-m", "target")
+	oldCommit, err := libDir.CommitHash(context.Background(), "HEAD")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	newCommit, err := subDir.CommitHash(context.Background(), "HEAD")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+
+	for _, inMemory := range []bool{false, true} {
+		res, err := Run(context.Background(), []string{repoDir.WorkDir.Dir}, RunOpts{
+			Base:     "main",
+			Target:   "a-branch",
+			InMemory: inMemory,
+		})
+		if !assert.NoError(t, err) {
+			t.FailNow()
+		}
+		if assert.Len(t, res.SubmoduleChanges, 1) {
+			assert.Equal(t, "lib", res.SubmoduleChanges[0].Path)
+			assert.True(t, strings.HasPrefix(res.SubmoduleChanges[0].Old, oldCommit))
+			assert.True(t, strings.HasPrefix(res.SubmoduleChanges[0].New, newCommit))
+		}
+		assert.Equal(t, []ImageChange{
+			{Resource: "Pod/lib", Container: "lib", Old: "nginx:1.20", New: "nginx:1.21"},
+		}, res.DiffMap.ImageChanges["overlay"], "in-memory: %t", inMemory)
+	}
+
+	// The local URLs in .gitmodules of the tree aren't cloned.
+	git(repoDir, "-c", "protocol.file.allow=always", "submodule", "add", "-q", libDir.WorkDir.Dir, "other")
+	git(repoDir, "commit", "-q", "-m", "other")
+	git(repoDir, "submodule", "deinit", "-q", "-f", "other")
+	_, err = Run(context.Background(), []string{repoDir.WorkDir.Dir}, RunOpts{
+		Base:   "main",
+		Target: "a-branch",
+	})
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "transport 'file' not allowed")
+	}
+}
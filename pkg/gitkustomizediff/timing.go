@@ -0,0 +1,116 @@
+/*
+Copyright 2021 Daisuke Taniwaki.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gitkustomizediff
+
+import (
+	"encoding/json"
+	"sort"
+	"time"
+
+	"github.com/dtaniwaki/git-kustomize-diff/pkg/utils"
+)
+
+// StepTiming is the duration of a git step.
+type StepTiming struct {
+	// Step is the side and the name of the step, e.g. "base fetch".
+	Step     string
+	Duration time.Duration
+}
+
+// MarshalJSON encodes the timing with the duration in seconds.
+func (t StepTiming) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Step     string  `json:"step"`
+		Duration float64 `json:"duration"`
+	}{
+		Step:     t.Step,
+		Duration: t.Duration.Seconds(),
+	})
+}
+
+// KustomizationTiming is the durations of the builds and the diff of a directory.
+type KustomizationTiming struct {
+	Dir         string
+	BaseBuild   time.Duration
+	TargetBuild time.Duration
+	Diff        time.Duration
+}
+
+// Total returns the sum of the durations.
+func (t KustomizationTiming) Total() time.Duration {
+	return t.BaseBuild + t.TargetBuild + t.Diff
+}
+
+// MarshalJSON encodes the timing with the durations in seconds.
+func (t KustomizationTiming) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Dir         string  `json:"dir"`
+		BaseBuild   float64 `json:"base_build"`
+		TargetBuild float64 `json:"target_build"`
+		Diff        float64 `json:"diff"`
+		Total       float64 `json:"total"`
+	}{
+		Dir:         t.Dir,
+		BaseBuild:   t.BaseBuild.Seconds(),
+		TargetBuild: t.TargetBuild.Seconds(),
+		Diff:        t.Diff.Seconds(),
+		Total:       t.Total().Seconds(),
+	})
+}
+
+// Timings is the performance report of a run.
+type Timings struct {
+	GitSteps []StepTiming `json:"git_steps"`
+	// Kustomizations are sorted from the slowest.
+	Kustomizations []KustomizationTiming `json:"kustomizations"`
+}
+
+// Timings returns the durations of the git steps and the kustomizations.
+func (res *RunResult) Timings() Timings {
+	return Timings{
+		GitSteps:       append([]StepTiming{}, res.GitSteps...),
+		Kustomizations: res.DiffMap.KustomizationTimings(),
+	}
+}
+
+// emitFinished emits the timings of the finished run.
+func (res *RunResult) emitFinished(progress ProgressFunc) {
+	timings := res.Timings()
+	progress.emit(ProgressEvent{Type: ProgressEventRunFinished, Commit: res.TargetCommit, Timings: &timings})
+}
+
+// KustomizationTimings returns the durations of the kustomizations from the slowest.
+func (m *DiffMap) KustomizationTimings() []KustomizationTiming {
+	kustomizations := make([]KustomizationTiming, 0, len(m.Timings))
+	for _, timing := range m.Timings {
+		kustomizations = append(kustomizations, timing)
+	}
+	sort.SliceStable(kustomizations, func(i, j int) bool {
+		if kustomizations[i].Total() != kustomizations[j].Total() {
+			return kustomizations[i].Total() > kustomizations[j].Total()
+		}
+		return kustomizations[i].Dir < kustomizations[j].Dir
+	})
+	return kustomizations
+}
+
+// recordStep returns a timer appending the steps of the side to the timings.
+func recordStep(timings *[]StepTiming, side string) utils.StepTimer {
+	return func(step string, duration time.Duration) {
+		*timings = append(*timings, StepTiming{Step: side + " " + step, Duration: duration})
+	}
+}
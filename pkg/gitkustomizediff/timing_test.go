@@ -0,0 +1,110 @@
+/*
+Copyright 2021 Daisuke Taniwaki.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gitkustomizediff
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRunResultTimings(t *testing.T) {
+	diffMap := NewDiffMap()
+	diffMap.Timings["fast"] = KustomizationTiming{Dir: "fast", BaseBuild: time.Second, TargetBuild: time.Second}
+	diffMap.Timings["slow"] = KustomizationTiming{Dir: "slow", BaseBuild: 2 * time.Second, TargetBuild: 3 * time.Second, Diff: 500 * time.Millisecond}
+	diffMap.Timings["also-fast"] = KustomizationTiming{Dir: "also-fast", TargetBuild: 2 * time.Second}
+	res := &RunResult{
+		DiffMap:  diffMap,
+		GitSteps: []StepTiming{{Step: "base clone", Duration: 1500 * time.Millisecond}},
+	}
+
+	timings := res.Timings()
+	dirs := make([]string, 0)
+	for _, timing := range timings.Kustomizations {
+		dirs = append(dirs, timing.Dir)
+	}
+	assert.Equal(t, []string{"slow", "also-fast", "fast"}, dirs)
+	assert.Equal(t, 5500*time.Millisecond, timings.Kustomizations[0].Total())
+
+	bs, err := json.Marshal(timings)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	assert.JSONEq(t, `{
+  "git_steps": [{"step": "base clone", "duration": 1.5}],
+  "kustomizations": [
+    {"dir": "slow", "base_build": 2, "target_build": 3, "diff": 0.5, "total": 5.5},
+    {"dir": "also-fast", "base_build": 0, "target_build": 2, "diff": 0, "total": 2},
+    {"dir": "fast", "base_build": 1, "target_build": 1, "diff": 0, "total": 2}
+  ]
+}`, string(bs))
+}
+
+func TestRunTimings(t *testing.T) {
+	tmpGitDir := makeFixtureRepo(t)
+	defer os.RemoveAll(tmpGitDir)
+
+	for _, inMemory := range []bool{false, true} {
+		var finished *ProgressEvent
+		res, err := Run(context.Background(), []string{tmpGitDir}, RunOpts{
+			Base:     "main",
+			Target:   "a-branch",
+			InMemory: inMemory,
+			Progress: func(event ProgressEvent) {
+				if event.Type == ProgressEventRunFinished {
+					finished = &event
+				}
+			},
+		})
+		if !assert.NoError(t, err) {
+			t.FailNow()
+		}
+		steps := make([]string, 0)
+		for _, step := range res.GitSteps {
+			steps = append(steps, step.Step)
+		}
+		if inMemory {
+			assert.Equal(t, []string{"base load tree", "target merge tree", "target load tree"}, steps)
+		} else {
+			assert.Equal(t, []string{
+				"base clone", "base copy config", "base set user", "base fetch", "base checkout", "base submodules",
+				"target clone", "target copy config", "target set user", "target fetch", "target checkout", "target merge", "target submodules",
+			}, steps)
+		}
+		for _, dir := range []string{"invalid", "sub1", "sub2"} {
+			timing, ok := res.DiffMap.Timings[dir]
+			if assert.True(t, ok, dir) {
+				assert.Equal(t, dir, timing.Dir)
+				assert.Equal(t, timing.BaseBuild+timing.TargetBuild+timing.Diff, timing.Total())
+			}
+		}
+		// The timings are emitted for the JSON progress.
+		if assert.NotNil(t, finished) {
+			assert.Equal(t, res.TargetCommit, finished.Commit)
+			assert.Equal(t, res.Timings(), *finished.Timings)
+			bs, err := json.Marshal(finished)
+			if !assert.NoError(t, err) {
+				t.FailNow()
+			}
+			assert.Contains(t, string(bs), `"timings":{"git_steps":[{"step":`)
+		}
+	}
+}
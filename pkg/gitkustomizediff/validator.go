@@ -0,0 +1,332 @@
+/*
+Copyright 2021 Daisuke Taniwaki.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gitkustomizediff
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	"k8s.io/kube-openapi/pkg/validation/spec"
+	"k8s.io/kube-openapi/pkg/validation/strfmt"
+	"k8s.io/kube-openapi/pkg/validation/validate"
+	"sigs.k8s.io/kustomize/kyaml/openapi/kubernetesapi"
+	"sigs.k8s.io/kustomize/kyaml/yaml"
+)
+
+const (
+	gvkExtension             = "x-kubernetes-group-version-kind"
+	preserveUnknownExtension = "x-kubernetes-preserve-unknown-fields"
+	quantityDefinition       = "io.k8s.apimachinery.pkg.api.resource.Quantity"
+)
+
+type ValidatorOpts struct {
+	// KubeVersion is the Kubernetes version of the bundled schemas, e.g. 1.21.
+	// Defaults to the default version of kustomize.
+	KubeVersion string
+	// CRDFiles are files of CustomResourceDefinitions to validate custom resources.
+	CRDFiles []string
+}
+
+// Validator validates resources against the OpenAPI schemas of Kubernetes
+// bundled in kustomize and CustomResourceDefinitions. Nothing is fetched from
+// the network. Resources of unknown kinds are not validated.
+type Validator struct {
+	kubeVersion string
+	definitions spec.Definitions
+	gvkNames    map[schemaGVK]string
+	crdSchemas  map[schemaGVK]*spec.Schema
+	expanded    map[string]*spec.Schema
+	mu          sync.Mutex
+}
+
+type schemaGVK struct {
+	group   string
+	version string
+	kind    string
+}
+
+func NewValidator(opts ValidatorOpts) (*Validator, error) {
+	kubeVersion, err := bundledKubeVersion(opts.KubeVersion)
+	if err != nil {
+		return nil, err
+	}
+	swagger := &spec.Swagger{}
+	err = swagger.UnmarshalJSON(kubernetesapi.OpenAPIMustAsset[kubeVersion](filepath.Join("kubernetesapi", kubeVersion, "swagger.json")))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	v := &Validator{
+		kubeVersion: kubeVersion,
+		definitions: swagger.Definitions,
+		gvkNames:    make(map[schemaGVK]string),
+		crdSchemas:  make(map[schemaGVK]*spec.Schema),
+		expanded:    make(map[string]*spec.Schema),
+	}
+	for name, def := range swagger.Definitions {
+		gvks, ok := def.Extensions[gvkExtension].([]interface{})
+		if !ok || len(gvks) != 1 {
+			// Skip the common types like DeleteOptions.
+			continue
+		}
+		gvk, ok := gvks[0].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		v.gvkNames[schemaGVK{
+			group:   fmt.Sprint(gvk["group"]),
+			version: fmt.Sprint(gvk["version"]),
+			kind:    fmt.Sprint(gvk["kind"]),
+		}] = name
+	}
+	for _, file := range opts.CRDFiles {
+		err := v.loadCRDFile(file)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return v, nil
+}
+
+// bundledKubeVersion returns the name of the bundled schema of the version.
+// A minor version matches the latest bundled patch version.
+func bundledKubeVersion(version string) (string, error) {
+	if version == "" {
+		return kubernetesapi.DefaultOpenAPI, nil
+	}
+	version = strings.TrimPrefix(version, "v")
+	names := make([]string, 0, len(kubernetesapi.OpenAPIMustAsset))
+	for name := range kubernetesapi.OpenAPIMustAsset {
+		names = append(names, name)
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(names)))
+	versions := make([]string, 0, len(names))
+	for _, name := range names {
+		var swagger struct {
+			Info struct {
+				Version string `json:"version"`
+			} `json:"info"`
+		}
+		err := json.Unmarshal(kubernetesapi.OpenAPIMustAsset[name](filepath.Join("kubernetesapi", name, "swagger.json")), &swagger)
+		if err != nil {
+			return "", errors.WithStack(err)
+		}
+		bundledVersion := strings.TrimPrefix(swagger.Info.Version, "v")
+		if bundledVersion == version || strings.HasPrefix(bundledVersion, version+".") {
+			return name, nil
+		}
+		versions = append(versions, bundledVersion)
+	}
+	return "", fmt.Errorf("Kubernetes version %s is not bundled (available: %s)", version, strings.Join(versions, ", "))
+}
+
+// KubeVersion returns the name of the bundled schema in use.
+func (v *Validator) KubeVersion() string {
+	return v.kubeVersion
+}
+
+func (v *Validator) loadCRDFile(file string) error {
+	bs, err := ioutil.ReadFile(file)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	resources, err := parseResources(string(bs))
+	if err != nil {
+		return err
+	}
+	for _, rn := range resources {
+		if rn.GetKind() != "CustomResourceDefinition" {
+			continue
+		}
+		data, err := resourceData(rn)
+		if err != nil {
+			return err
+		}
+		group, _ := lookupValue(data, "spec", "group").(string)
+		kind, _ := lookupValue(data, "spec", "names", "kind").(string)
+		// apiextensions.k8s.io/v1beta1 has a schema for all the versions.
+		commonSchema := lookupValue(data, "spec", "validation", "openAPIV3Schema")
+		versions, _ := lookupValue(data, "spec", "versions").([]interface{})
+		if version, ok := lookupValue(data, "spec", "version").(string); ok {
+			versions = append(versions, map[string]interface{}{"name": version})
+		}
+		for _, version := range versions {
+			name, _ := lookupValue(version, "name").(string)
+			schemaData := lookupValue(version, "schema", "openAPIV3Schema")
+			if schemaData == nil {
+				schemaData = commonSchema
+			}
+			if schemaData == nil {
+				continue
+			}
+			schemaJSON, err := json.Marshal(schemaData)
+			if err != nil {
+				return errors.WithStack(err)
+			}
+			schema := &spec.Schema{}
+			err = schema.UnmarshalJSON(schemaJSON)
+			if err != nil {
+				return errors.Wrapf(err, "invalid schema of %s in %s", rn.GetName(), file)
+			}
+			v.crdSchemas[schemaGVK{group: group, version: name, kind: kind}] = v.expand(schema, map[string]bool{}, false)
+		}
+	}
+	return nil
+}
+
+func lookupValue(data interface{}, path ...string) interface{} {
+	for _, key := range path {
+		m, ok := data.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		data = m[key]
+	}
+	return data
+}
+
+// Validate validates the resources in the built YAML and returns the errors.
+func (v *Validator) Validate(s string) ([]string, error) {
+	resources, err := parseResources(s)
+	if err != nil {
+		return nil, err
+	}
+	messages := make([]string, 0)
+	for _, rn := range resources {
+		schema := v.schemaFor(rn)
+		if schema == nil {
+			continue
+		}
+		data, err := resourceData(rn)
+		if err != nil {
+			return nil, err
+		}
+		res := validate.NewSchemaValidator(schema, nil, "", strfmt.Default).Validate(data)
+		for _, e := range res.Errors {
+			messages = append(messages, fmt.Sprintf("%s: %s", resourceID(rn), strings.TrimPrefix(e.Error(), ".")))
+		}
+	}
+	return messages, nil
+}
+
+func (v *Validator) schemaFor(rn *yaml.RNode) *spec.Schema {
+	gvk := schemaGVK{kind: rn.GetKind()}
+	gvk.version = rn.GetApiVersion()
+	if idx := strings.Index(gvk.version, "/"); idx >= 0 {
+		gvk.group, gvk.version = gvk.version[:idx], gvk.version[idx+1:]
+	}
+	if schema, ok := v.crdSchemas[gvk]; ok {
+		return schema
+	}
+	name, ok := v.gvkNames[gvk]
+	if !ok {
+		return nil
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.expandDefinition(name, map[string]bool{})
+}
+
+// expandDefinition returns the definition with the references expanded as the
+// validator doesn't support them.
+func (v *Validator) expandDefinition(name string, inProgress map[string]bool) *spec.Schema {
+	if schema, ok := v.expanded[name]; ok {
+		return schema
+	}
+	def, ok := v.definitions[name]
+	if !ok || inProgress[name] {
+		// Accept anything for unknown or recursive definitions.
+		return &spec.Schema{}
+	}
+	inProgress[name] = true
+	defer delete(inProgress, name)
+	schema := v.expand(&def, inProgress, true)
+	if name == quantityDefinition {
+		// Quantities can be numbers in YAML.
+		schema.Type = nil
+	}
+	v.expanded[name] = schema
+	return schema
+}
+
+// expand returns a copy of the schema with the references expanded.
+// Objects of closed schemas reject unknown fields like kubectl does.
+// null is accepted everywhere as the API server treats it as an absent field.
+func (v *Validator) expand(s *spec.Schema, inProgress map[string]bool, closed bool) *spec.Schema {
+	if ref := s.Ref.String(); ref != "" {
+		return v.expandDefinition(strings.TrimPrefix(ref, "#/definitions/"), inProgress)
+	}
+	out := *s
+	out.Nullable = true
+	if out.Format == "int-or-string" {
+		out.Type = nil
+		out.Format = ""
+	}
+	if preserveUnknown, _ := s.Extensions[preserveUnknownExtension].(bool); preserveUnknown {
+		closed = false
+	}
+	if len(s.Properties) > 0 {
+		out.Properties = make(map[string]spec.Schema, len(s.Properties))
+		for key, prop := range s.Properties {
+			prop := prop
+			out.Properties[key] = *v.expand(&prop, inProgress, closed)
+		}
+		if closed && s.AdditionalProperties == nil {
+			out.AdditionalProperties = &spec.SchemaOrBool{Allows: false}
+		}
+	}
+	if s.AdditionalProperties != nil && s.AdditionalProperties.Schema != nil {
+		out.AdditionalProperties = &spec.SchemaOrBool{
+			Allows: true,
+			Schema: v.expand(s.AdditionalProperties.Schema, inProgress, closed),
+		}
+	}
+	if s.Items != nil {
+		out.Items = &spec.SchemaOrArray{}
+		if s.Items.Schema != nil {
+			out.Items.Schema = v.expand(s.Items.Schema, inProgress, closed)
+		}
+		for _, item := range s.Items.Schemas {
+			item := item
+			out.Items.Schemas = append(out.Items.Schemas, *v.expand(&item, inProgress, closed))
+		}
+	}
+	out.AllOf = v.expandAll(s.AllOf, inProgress, closed)
+	out.AnyOf = v.expandAll(s.AnyOf, inProgress, closed)
+	out.OneOf = v.expandAll(s.OneOf, inProgress, closed)
+	if s.Not != nil {
+		out.Not = v.expand(s.Not, inProgress, closed)
+	}
+	return &out
+}
+
+func (v *Validator) expandAll(schemas []spec.Schema, inProgress map[string]bool, closed bool) []spec.Schema {
+	if schemas == nil {
+		return nil
+	}
+	out := make([]spec.Schema, 0, len(schemas))
+	for _, schema := range schemas {
+		schema := schema
+		out = append(out, *v.expand(&schema, inProgress, closed))
+	}
+	return out
+}
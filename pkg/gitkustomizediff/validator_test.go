@@ -0,0 +1,120 @@
+/*
+Copyright 2021 Daisuke Taniwaki.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gitkustomizediff
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidator(t *testing.T) {
+	wd, _ := os.Getwd()
+
+	v, err := NewValidator(ValidatorOpts{
+		CRDFiles: []string{filepath.Join(wd, "fixtures", "validate", "crd.yaml")},
+	})
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+
+	validationErrors, err := v.Validate(strings.TrimLeft(`
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: foo
+  namespace: default
+  creationTimestamp: null
+spec:
+  selector:
+    matchLabels:
+      app: foo
+  template:
+    metadata:
+      labels:
+        app: foo
+    spec:
+      containers:
+      - name: foo
+        image: nginx
+        imagePullPolice: Always
+        resources:
+          limits:
+            cpu: 1
+        ports:
+        - containerPort: 80
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: foo
+spec:
+  ports:
+  - port: 80
+    targetPort: http
+---
+apiVersion: example.com/v1
+kind: Foo
+metadata:
+  name: foo
+spec:
+  size: large
+---
+apiVersion: example.com/v2
+kind: Unknown
+metadata:
+  name: foo
+`, "\n"))
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	assert.Equal(t, []string{
+		"Deployment.apps/default/foo: spec.template.spec.containers.imagePullPolice in body is a forbidden property",
+		"Foo.example.com/foo: spec.size in body must be of type integer: \"string\"",
+	}, validationErrors)
+}
+
+func TestBundledKubeVersion(t *testing.T) {
+	for _, version := range []string{"", "1.21", "v1.21", "1.21.2", "v1.21.2"} {
+		name, err := bundledKubeVersion(version)
+		if assert.NoError(t, err, version) {
+			assert.Equal(t, "v1212", name)
+		}
+	}
+	_, err := bundledKubeVersion("1.2")
+	assert.Error(t, err)
+}
+
+func TestDiffValidation(t *testing.T) {
+	wd, _ := os.Getwd()
+
+	v, err := NewValidator(ValidatorOpts{})
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	baseDirPath := filepath.Join(wd, "fixtures", "diff", "base")
+	targetDirPath := filepath.Join(wd, "fixtures", "diff", "target")
+	diffMap, err := Diff(context.Background(), baseDirPath, targetDirPath, DiffOpts{Validator: v})
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	assert.Equal(t, map[string][]string{}, diffMap.Validations)
+}
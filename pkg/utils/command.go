@@ -18,9 +18,11 @@ package utils
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"os"
 	"os/exec"
+	"time"
 
 	"github.com/pkg/errors"
 )
@@ -39,6 +41,10 @@ func (ce *CommandError) Error() string {
 	return fmt.Sprintf("%s\n\n[stdout]\n%s\n\n[stderr]\n%s", ce.InternalError, ce.Stdout, ce.Stderr)
 }
 
+func (ce *CommandError) Unwrap() error {
+	return ce.InternalError
+}
+
 func GetExitCode(err error) *int {
 	cause, ok := err.(WithCause)
 	if !ok {
@@ -56,13 +62,20 @@ func GetExitCode(err error) *int {
 	return &code
 }
 
+// commandWaitDelay is how long the outputs of a command are waited for after
+// its context is done.
+const commandWaitDelay = time.Second
+
 type WorkDir struct {
 	Dir string
 	Env map[string]string
 }
 
-func (wd *WorkDir) RunCommand(command string, args ...string) (string, string, error) {
-	cmd := exec.Command(command, args...)
+// RunCommand runs the command in the work dir. The command is killed when the
+// context is done, and the error of the failed command wraps the error of the
+// context.
+func (wd *WorkDir) RunCommand(ctx context.Context, command string, args ...string) (string, string, error) {
+	cmd := exec.CommandContext(ctx, command, args...)
 	stdout := bytes.NewBufferString("")
 	stderr := bytes.NewBufferString("")
 	cmd.Dir = wd.Dir
@@ -74,8 +87,13 @@ func (wd *WorkDir) RunCommand(command string, args ...string) (string, string, e
 	cmd.Env = env
 	cmd.Stdout = stdout
 	cmd.Stderr = stderr
+	// Don't wait forever for the children of the killed process holding the outputs.
+	cmd.WaitDelay = commandWaitDelay
 	err := cmd.Run()
 	if err != nil {
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		}
 		err = errors.WithStack(&CommandError{
 			InternalError: err,
 			Stdout:        stdout.String(),
@@ -17,15 +17,22 @@ limitations under the License.
 package utils
 
 import (
+	"context"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/pkg/errors"
 	"github.com/yookoala/realpath"
 )
 
+// gitlinkMode is the mode of the submodule entries in the trees.
+const gitlinkMode = "160000"
+
 type GitDir struct {
 	GitPath string
 	WorkDir WorkDir
@@ -40,52 +47,99 @@ func NewGitDir(dirPath, gitPath string) *GitDir {
 	}
 }
 
-func (gd *GitDir) RunGitCommand(args ...string) (string, string, error) {
+func (gd *GitDir) RunGitCommand(ctx context.Context, args ...string) (string, string, error) {
 	gitPath := gd.GitPath
 	if gitPath == "" {
 		gitPath = "git"
 	}
-	return gd.WorkDir.RunCommand(gitPath, args...)
+	return gd.WorkDir.RunCommand(ctx, gitPath, args...)
 }
 
-func (gd *GitDir) CommitHash(target string) (string, error) {
-	stdout, _, err := gd.RunGitCommand("rev-parse", "-q", "--short", target)
+// CommitHash returns the full hash of the commit the target refers to. Tags
+// are peeled to their commits and the other objects like trees are rejected.
+func (gd *GitDir) CommitHash(ctx context.Context, target string) (string, error) {
+	stdout, _, err := gd.RunGitCommand(ctx, "rev-parse", "-q", "--verify", target+"^{commit}")
 	if err != nil {
+		// The exit code is 1 if the target doesn't refer to a commit.
+		if code := GetExitCode(err); code != nil && *code == 1 {
+			return "", errors.Errorf("%s is not a commit", target)
+		}
 		return "", err
 	}
 	return strings.Trim(stdout, "\n"), nil
 }
 
-func (gd *GitDir) Diff(target string) (string, error) {
-	stdout, _, err := gd.RunGitCommand("diff", target)
+// shortHashLength is the length of the abbreviated hashes.
+const shortHashLength = 7
+
+// ShortHash abbreviates the hash for the reports. The full hash is used to
+// refer to the object because the abbreviated one can be ambiguous.
+func ShortHash(hash string) string {
+	if len(hash) > shortHashLength {
+		return hash[:shortHashLength]
+	}
+	return hash
+}
+
+// RevList returns the commits reachable from the target but not from the base
+// from the oldest.
+func (gd *GitDir) RevList(ctx context.Context, base, target string) ([]string, error) {
+	stdout, _, err := gd.RunGitCommand(ctx, "rev-list", "--reverse", base+".."+target)
 	if err != nil {
+		return nil, err
+	}
+	return strings.Fields(stdout), nil
+}
+
+// FirstParent returns the full hash of the first parent of the commit, or an
+// empty string if the commit is a root commit.
+func (gd *GitDir) FirstParent(ctx context.Context, commit string) (string, error) {
+	stdout, _, err := gd.RunGitCommand(ctx, "rev-parse", "-q", "--verify", commit+"^1^{commit}")
+	if err != nil {
+		// The exit code is 1 if the commit has no parent.
+		if code := GetExitCode(err); code != nil && *code == 1 {
+			return "", nil
+		}
 		return "", err
 	}
-	return stdout, nil
+	return strings.Trim(stdout, "\n"), nil
 }
 
-func (gd *GitDir) CurrentBranch() (string, error) {
-	stdout, _, err := gd.RunGitCommand("branch", "--show-current")
+// CommitSubject returns the first line of the commit message.
+func (gd *GitDir) CommitSubject(ctx context.Context, commit string) (string, error) {
+	stdout, _, err := gd.RunGitCommand(ctx, "log", "-1", "--format=%s", commit)
 	if err != nil {
 		return "", err
 	}
 	return strings.Trim(stdout, "\n"), nil
 }
 
-func (gd *GitDir) Clone(dstDirPath string) (*GitDir, error) {
-	rootDir, err := gd.GetRootDir()
+func (gd *GitDir) Diff(ctx context.Context, target string) (string, error) {
+	stdout, _, err := gd.RunGitCommand(ctx, "diff", target)
 	if err != nil {
-		return nil, err
+		return "", err
+	}
+	return stdout, nil
+}
+
+func (gd *GitDir) CurrentBranch(ctx context.Context) (string, error) {
+	stdout, _, err := gd.RunGitCommand(ctx, "branch", "--show-current")
+	if err != nil {
+		return "", err
 	}
-	_, _, err = gd.RunGitCommand("clone", rootDir, dstDirPath)
+	return strings.Trim(stdout, "\n"), nil
+}
+
+func (gd *GitDir) Clone(ctx context.Context, dstDirPath string) (*GitDir, error) {
+	rootDir, err := gd.GetRootDir(ctx)
 	if err != nil {
 		return nil, err
 	}
-	absPath, err := realpath.Realpath(gd.WorkDir.Dir)
+	_, _, err = gd.RunGitCommand(ctx, "clone", rootDir, dstDirPath)
 	if err != nil {
 		return nil, err
 	}
-	relPath, err := filepath.Rel(rootDir, absPath)
+	relPath, err := gd.RelPath(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -95,21 +149,38 @@ func (gd *GitDir) Clone(dstDirPath string) (*GitDir, error) {
 	}, nil
 }
 
-func (gd *GitDir) GetRootDir() (string, error) {
+// RelPath returns the path of the work dir relative to the repository root.
+func (gd *GitDir) RelPath(ctx context.Context) (string, error) {
+	rootDir, err := gd.GetRootDir(ctx)
+	if err != nil {
+		return "", err
+	}
+	absPath, err := realpath.Realpath(gd.WorkDir.Dir)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	relPath, err := filepath.Rel(rootDir, absPath)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	return relPath, nil
+}
+
+func (gd *GitDir) GetRootDir(ctx context.Context) (string, error) {
 	// `git rev-parse --show-toplevel` returns a real path.
-	baseDirPath, _, err := gd.RunGitCommand("rev-parse", "--show-toplevel")
+	baseDirPath, _, err := gd.RunGitCommand(ctx, "rev-parse", "--show-toplevel")
 	if err != nil {
 		return "", err
 	}
 	return strings.Trim(baseDirPath, "\n"), nil
 }
 
-func (gd *GitDir) CopyConfig(targetGitDir *GitDir) error {
-	baseDirPath, err := gd.GetRootDir()
+func (gd *GitDir) CopyConfig(ctx context.Context, targetGitDir *GitDir) error {
+	baseDirPath, err := gd.GetRootDir(ctx)
 	if err != nil {
 		return err
 	}
-	targetDirPath, err := targetGitDir.GetRootDir()
+	targetDirPath, err := targetGitDir.GetRootDir(ctx)
 	if err != nil {
 		return err
 	}
@@ -145,31 +216,31 @@ func (gd *GitDir) CopyConfig(targetGitDir *GitDir) error {
 	return nil
 }
 
-func (gd *GitDir) Fetch() error {
-	_, _, err := gd.RunGitCommand("fetch", "--all")
+func (gd *GitDir) Fetch(ctx context.Context) error {
+	_, _, err := gd.RunGitCommand(ctx, "fetch", "--all")
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
-func (gd *GitDir) Checkout(target string) error {
-	_, _, err := gd.RunGitCommand("checkout", target)
+func (gd *GitDir) Checkout(ctx context.Context, target string) error {
+	_, _, err := gd.RunGitCommand(ctx, "checkout", target)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
-func (gd *GitDir) Merge(target string) error {
-	_, _, err := gd.RunGitCommand("merge", "--no-ff", target)
+func (gd *GitDir) Merge(ctx context.Context, target string) error {
+	_, _, err := gd.RunGitCommand(ctx, "merge", "--no-ff", target)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
-func (gd *GitDir) Apply(patch string) error {
+func (gd *GitDir) Apply(ctx context.Context, patch string) error {
 	tmpFile, err := ioutil.TempFile("", "git-kustomize-diff-apply-")
 	if err != nil {
 		return errors.WithStack(err)
@@ -182,47 +253,292 @@ func (gd *GitDir) Apply(patch string) error {
 	if err != nil {
 		return errors.WithStack(err)
 	}
-	_, _, err = gd.RunGitCommand("apply", tmpFile.Name())
+	_, _, err = gd.RunGitCommand(ctx, "apply", tmpFile.Name())
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
-func (gd *GitDir) SetUser() error {
+func (gd *GitDir) SetUser(ctx context.Context) error {
 	email := "anonymous@example.com"
 	name := "anonymous"
-	_, _, err := gd.RunGitCommand("config", "user.email", email)
+	_, _, err := gd.RunGitCommand(ctx, "config", "user.email", email)
 	if err != nil {
 		return err
 	}
-	_, _, err = gd.RunGitCommand("config", "user.name", name)
+	_, _, err = gd.RunGitCommand(ctx, "config", "user.name", name)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
-func (gd *GitDir) CloneAndCheckout(dirPath, commit string) (*GitDir, error) {
-	gitDir, err := gd.Clone(dirPath)
+// StepTimer receives the duration of each step.
+type StepTimer func(step string, duration time.Duration)
+
+// Time runs the step and records its duration if the timer is set.
+func (t StepTimer) Time(step string, f func() error) error {
+	start := time.Now()
+	err := f()
+	if t != nil {
+		t(step, time.Since(start))
+	}
+	return err
+}
+
+// CloneAndCheckout clones the repo into the dir and checks out the commit.
+// The timer receives the duration of each git step.
+func (gd *GitDir) CloneAndCheckout(ctx context.Context, dirPath, commit string, timer StepTimer) (*GitDir, error) {
+	var gitDir *GitDir
+	err := timer.Time("clone", func() (err error) {
+		gitDir, err = gd.Clone(ctx, dirPath)
+		return err
+	})
 	if err != nil {
 		return nil, err
 	}
-	err = gd.CopyConfig(gitDir)
+	err = timer.Time("copy config", func() error {
+		return gd.CopyConfig(ctx, gitDir)
+	})
 	if err != nil {
 		return nil, err
 	}
-	err = gitDir.SetUser()
+	err = timer.Time("set user", func() error {
+		return gitDir.SetUser(ctx)
+	})
 	if err != nil {
 		return nil, err
 	}
-	err = gitDir.Fetch()
+	err = timer.Time("fetch", func() error {
+		return gitDir.Fetch(ctx)
+	})
 	if err != nil {
 		return nil, err
 	}
-	err = gitDir.Checkout(commit)
+	err = timer.Time("checkout", func() error {
+		return gitDir.Checkout(ctx, commit)
+	})
 	if err != nil {
 		return nil, err
 	}
 	return gitDir, nil
 }
+
+// UpdateSubmodules checks out the submodules at the commits recorded in the
+// work tree. The submodules initialized in the source repo are cloned from
+// there instead of their remote URLs.
+func (gd *GitDir) UpdateSubmodules(ctx context.Context, src *GitDir) error {
+	rootDir, err := gd.GetRootDir(ctx)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(filepath.Join(rootDir, ".gitmodules")); os.IsNotExist(err) {
+		return nil
+	}
+	root := NewGitDir(rootDir, gd.GitPath)
+	srcRootDir, err := src.GetRootDir(ctx)
+	if err != nil {
+		return err
+	}
+	paths, err := root.submodulePaths(ctx)
+	if err != nil {
+		return err
+	}
+	localPaths := make([]string, 0)
+	for name, path := range paths {
+		srcPath := filepath.Join(srcRootDir, path)
+		if _, err := os.Stat(filepath.Join(srcPath, ".git")); err != nil {
+			continue
+		}
+		_, _, err = root.RunGitCommand(ctx, "config", "submodule."+name+".url", srcPath)
+		if err != nil {
+			return err
+		}
+		localPaths = append(localPaths, path)
+	}
+	if len(localPaths) > 0 {
+		sort.Strings(localPaths)
+		// Local clones of submodules are disallowed by default since git 2.38.1.
+		// They are allowed only for the source paths set above and not for the
+		// URLs in .gitmodules of the tree.
+		args := append([]string{"-c", "protocol.file.allow=always", "submodule", "update", "--init", "--"}, localPaths...)
+		_, _, err = root.RunGitCommand(ctx, args...)
+		if err != nil {
+			return err
+		}
+		for _, path := range localPaths {
+			err := NewGitDir(filepath.Join(rootDir, path), gd.GitPath).UpdateSubmodules(ctx, NewGitDir(filepath.Join(srcRootDir, path), src.GitPath))
+			if err != nil {
+				return err
+			}
+		}
+	}
+	_, _, err = root.RunGitCommand(ctx, "submodule", "update", "--init", "--recursive")
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// submodulePaths returns the paths of the submodules in .gitmodules by their names.
+func (gd *GitDir) submodulePaths(ctx context.Context) (map[string]string, error) {
+	paths := make(map[string]string)
+	stdout, _, err := gd.RunGitCommand(ctx, "config", "-f", ".gitmodules", "-z", "--get-regexp", `^submodule\..*\.path$`)
+	if err != nil {
+		// The exit code is 1 if no submodule is found.
+		if code := GetExitCode(err); code != nil && *code == 1 {
+			return paths, nil
+		}
+		return nil, err
+	}
+	for _, entry := range strings.Split(stdout, "\x00") {
+		// <key> LF <value>
+		kv := strings.SplitN(entry, "\n", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		name := strings.TrimSuffix(strings.TrimPrefix(kv[0], "submodule."), ".path")
+		paths[name] = kv[1]
+	}
+	return paths, nil
+}
+
+// SubmoduleChange is a change of the commit of a submodule.
+// Old or New is empty if the submodule is added or removed.
+type SubmoduleChange struct {
+	Path string
+	Old  string
+	New  string
+}
+
+// SubmoduleChanges returns the changes of the submodules from the merge base
+// of the commits to the target.
+func (gd *GitDir) SubmoduleChanges(ctx context.Context, base, target string) ([]SubmoduleChange, error) {
+	stdout, _, err := gd.RunGitCommand(ctx, "diff", "--raw", "-z", "--no-abbrev", "--no-renames", base+"..."+target)
+	if err != nil {
+		return nil, err
+	}
+	changes := make([]SubmoduleChange, 0)
+	entries := strings.Split(stdout, "\x00")
+	for i := 0; i+1 < len(entries); i += 2 {
+		// :<old mode> SP <new mode> SP <old object> SP <new object> SP <status> NUL <path> NUL
+		fields := strings.Fields(strings.TrimPrefix(entries[i], ":"))
+		if len(fields) != 5 {
+			continue
+		}
+		if fields[0] != gitlinkMode && fields[1] != gitlinkMode {
+			continue
+		}
+		change := SubmoduleChange{Path: entries[i+1]}
+		if fields[0] == gitlinkMode {
+			change.Old = fields[2]
+		}
+		if fields[1] == gitlinkMode {
+			change.New = fields[3]
+		}
+		changes = append(changes, change)
+	}
+	return changes, nil
+}
+
+// MergeTreeMinGitVersion is the first git version supporting
+// merge-tree --write-tree.
+const MergeTreeMinGitVersion = "2.38"
+
+// Version returns the version of git, e.g. "2.39.5".
+func (gd *GitDir) Version(ctx context.Context) (string, error) {
+	stdout, _, err := gd.RunGitCommand(ctx, "version")
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimPrefix(strings.TrimSpace(stdout), "git version "), nil
+}
+
+// VersionAtLeast returns whether the version is the min version or later.
+// The suffixes of the version numbers like "windows" in "2.39.0.windows.1" are
+// ignored.
+func VersionAtLeast(version, min string) bool {
+	versionNums := versionNumbers(version)
+	for i, minNum := range versionNumbers(min) {
+		num := 0
+		if i < len(versionNums) {
+			num = versionNums[i]
+		}
+		if num != minNum {
+			return num > minNum
+		}
+	}
+	return true
+}
+
+// versionNumbers returns the leading numbers of the dot-separated version.
+func versionNumbers(version string) []int {
+	nums := make([]int, 0)
+	for _, part := range strings.Split(version, ".") {
+		num, err := strconv.Atoi(part)
+		if err != nil {
+			break
+		}
+		nums = append(nums, num)
+	}
+	return nums
+}
+
+// MergeTree merges the target commit into the base commit without touching
+// the work tree and returns the hash of the resulting tree. It requires git
+// MergeTreeMinGitVersion or later.
+func (gd *GitDir) MergeTree(ctx context.Context, base, target string) (string, error) {
+	stdout, _, err := gd.RunGitCommand(ctx, "merge-tree", "--write-tree", "--no-messages", base, target)
+	if err != nil {
+		return "", err
+	}
+	return strings.SplitN(stdout, "\n", 2)[0], nil
+}
+
+// ApplyToTree applies the patch on top of the tree with a temporary index
+// and returns the hash of the resulting tree.
+func (gd *GitDir) ApplyToTree(ctx context.Context, tree, patch string) (string, error) {
+	rootDir, err := gd.GetRootDir(ctx)
+	if err != nil {
+		return "", err
+	}
+	indexDirPath, err := ioutil.TempDir("", "git-kustomize-diff-index-")
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	defer os.RemoveAll(indexDirPath)
+	patchFile, err := ioutil.TempFile("", "git-kustomize-diff-apply-")
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	defer (func() {
+		patchFile.Close()
+		os.Remove(patchFile.Name())
+	})()
+	_, err = patchFile.Write([]byte(patch))
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	indexGitDir := &GitDir{
+		GitPath: gd.GitPath,
+		WorkDir: WorkDir{
+			Dir: rootDir,
+			Env: map[string]string{"GIT_INDEX_FILE": filepath.Join(indexDirPath, "index")},
+		},
+	}
+	_, _, err = indexGitDir.RunGitCommand(ctx, "read-tree", tree)
+	if err != nil {
+		return "", err
+	}
+	_, _, err = indexGitDir.RunGitCommand(ctx, "apply", "--cached", patchFile.Name())
+	if err != nil {
+		return "", err
+	}
+	stdout, _, err := indexGitDir.RunGitCommand(ctx, "write-tree")
+	if err != nil {
+		return "", err
+	}
+	return strings.Trim(stdout, "\n"), nil
+}
@@ -0,0 +1,87 @@
+/*
+Copyright 2021 Daisuke Taniwaki.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCommitHash(t *testing.T) {
+	tmpGitDir, err := ioutil.TempDir("", "kustomize-diff-test-")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer os.RemoveAll(tmpGitDir)
+	gitDir := NewGitDir(tmpGitDir, "")
+	run := func(args ...string) {
+		_, _, err := gitDir.RunGitCommand(context.Background(), args...)
+		if !assert.NoError(t, err) {
+			t.FailNow()
+		}
+	}
+	run("init", "-q")
+	if !assert.NoError(t, gitDir.SetUser(context.Background())) {
+		t.FailNow()
+	}
+	if !assert.NoError(t, ioutil.WriteFile(filepath.Join(tmpGitDir, "a.yaml"), []byte("a: 1\n"), 0600)) {
+		t.FailNow()
+	}
+	run("add", "-A")
+	run("commit", "-q", "-m", "init")
+	run("tag", "-a", "-m", "v1", "v1")
+
+	commit, err := gitDir.CommitHash(context.Background(), "HEAD")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	assert.Len(t, commit, 40)
+	assert.Equal(t, commit[:7], ShortHash(commit))
+
+	// The annotated tag is peeled to the commit.
+	tagCommit, err := gitDir.CommitHash(context.Background(), "v1")
+	if assert.NoError(t, err) {
+		assert.Equal(t, commit, tagCommit)
+	}
+
+	_, err = gitDir.CommitHash(context.Background(), "HEAD^{tree}")
+	if assert.Error(t, err) {
+		assert.Equal(t, "HEAD^{tree} is not a commit", err.Error())
+	}
+	_, err = gitDir.CommitHash(context.Background(), "missing")
+	assert.Error(t, err)
+}
+
+func TestShortHash(t *testing.T) {
+	assert.Equal(t, "0123456", ShortHash("0123456789abcdef0123456789abcdef01234567"))
+	assert.Equal(t, "0123", ShortHash("0123"))
+}
+
+func TestVersionAtLeast(t *testing.T) {
+	assert.True(t, VersionAtLeast("2.38.0", "2.38"))
+	assert.True(t, VersionAtLeast("2.39.5", "2.38"))
+	assert.True(t, VersionAtLeast("3.0", "2.38"))
+	assert.True(t, VersionAtLeast("2.39.0.windows.1", "2.38"))
+	assert.False(t, VersionAtLeast("2.37.1", "2.38"))
+	assert.False(t, VersionAtLeast("2", "2.38"))
+	assert.False(t, VersionAtLeast("1.99", "2.38"))
+}
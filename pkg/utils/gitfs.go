@@ -0,0 +1,301 @@
+/*
+Copyright 2021 Daisuke Taniwaki.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	"sigs.k8s.io/kustomize/kyaml/filesys"
+)
+
+// GitTreeFs is a filesys.FileSystem backed by a git tree object.
+// The directory structure is loaded up front and the file contents are read
+// from the object database on first access, so nothing is checked out on disk.
+// The root of the tree is mapped to the root of the file system.
+// filesys.FileSystem doesn't take a context, so the file system keeps the
+// context it's created with and is only valid while the context is alive.
+type GitTreeFs struct {
+	filesys.FileSystem
+	ctx   context.Context
+	blobs map[string]gitBlob
+	mu    sync.Mutex
+}
+
+// gitBlob is a blob in the object database of the repository.
+type gitBlob struct {
+	gitDir *GitDir
+	object string
+}
+
+var _ filesys.FileSystem = &GitTreeFs{}
+
+// NewGitTreeFs returns a file system of the tree of the treeish in the repository.
+// The submodules are read from the repositories of the submodules initialized
+// in the work tree. The context is used to read the file contents later, so
+// the file system fails to read them once the context is done.
+func NewGitTreeFs(ctx context.Context, gitDir *GitDir, treeish string) (*GitTreeFs, error) {
+	gfs := &GitTreeFs{
+		FileSystem: filesys.MakeFsInMemory(),
+		ctx:        ctx,
+		blobs:      make(map[string]gitBlob),
+	}
+	rootDir, err := gitDir.GetRootDir(ctx)
+	if err != nil {
+		return nil, err
+	}
+	links := make(map[string]gitBlob)
+	err = gfs.addTree(ctx, NewGitDir(rootDir, gitDir.GitPath), treeish, "", links)
+	if err != nil {
+		return nil, err
+	}
+	err = gfs.addLinks(links)
+	if err != nil {
+		return nil, err
+	}
+	for filePath := range gfs.blobs {
+		err := gfs.FileSystem.MkdirAll(filepath.Dir(filePath))
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		err = gfs.FileSystem.WriteFile(filePath, []byte{})
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+	}
+	return gfs, nil
+}
+
+// addTree adds the blobs of the tree in the repository at the root dir under the prefix.
+func (gfs *GitTreeFs) addTree(ctx context.Context, gitDir *GitDir, treeish, prefix string, links map[string]gitBlob) error {
+	stdout, _, err := gitDir.RunGitCommand(ctx, "ls-tree", "-r", "-z", "--full-tree", treeish)
+	if err != nil {
+		return err
+	}
+	for _, entry := range strings.Split(stdout, "\x00") {
+		if entry == "" {
+			continue
+		}
+		// <mode> SP <type> SP <object> TAB <file>
+		tabIdx := strings.Index(entry, "\t")
+		if tabIdx < 0 {
+			return fmt.Errorf("Unexpected ls-tree entry: %s", entry)
+		}
+		fields := strings.Fields(entry[:tabIdx])
+		if len(fields) != 3 {
+			return fmt.Errorf("Unexpected ls-tree entry: %s", entry)
+		}
+		mode, objType, object := fields[0], fields[1], fields[2]
+		name := path.Join(prefix, entry[tabIdx+1:])
+		if mode == gitlinkMode {
+			subDir := NewGitDir(filepath.Join(gitDir.WorkDir.Dir, filepath.FromSlash(entry[tabIdx+1:])), gitDir.GitPath)
+			if _, err := os.Stat(filepath.Join(subDir.WorkDir.Dir, ".git")); err != nil {
+				// Submodules not initialized are not available.
+				continue
+			}
+			if _, _, err := subDir.RunGitCommand(ctx, "cat-file", "-e", object+"^{commit}"); err != nil {
+				return fmt.Errorf("Submodule %s at %s is not fetched", name, object)
+			}
+			err = gfs.addTree(ctx, subDir, object, name, links)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		if objType != "blob" {
+			continue
+		}
+		if mode == "120000" {
+			links[name] = gitBlob{gitDir: gitDir, object: object}
+			continue
+		}
+		gfs.blobs[gfs.pathOf(name)] = gitBlob{gitDir: gitDir, object: object}
+	}
+	return nil
+}
+
+// maxLinkHops is the max number of the links followed to resolve a path.
+const maxLinkHops = 40
+
+// addLinks adds the files the links refer to in the same tree under the links.
+// The links to directories are followed as well as the links to files.
+func (gfs *GitTreeFs) addLinks(links map[string]gitBlob) error {
+	linkTargets := make(map[string]string, len(links))
+	linkNames := make([]string, 0, len(links))
+	for name, link := range links {
+		linkTarget, err := gfs.catBlob(link)
+		if err != nil {
+			return err
+		}
+		if path.IsAbs(string(linkTarget)) {
+			// Absolute links are out of the tree.
+			linkTargets[name] = string(linkTarget)
+		} else {
+			linkTargets[name] = path.Join(path.Dir(name), string(linkTarget))
+		}
+		linkNames = append(linkNames, name)
+	}
+	sort.Strings(linkNames)
+	regularBlobs := make(map[string]gitBlob, len(gfs.blobs))
+	for blobPath, blob := range gfs.blobs {
+		regularBlobs[blobPath] = blob
+	}
+
+	type linkEntry struct {
+		name  string
+		depth int
+	}
+	entries := make([]linkEntry, 0, len(linkNames))
+	for _, name := range linkNames {
+		entries = append(entries, linkEntry{name: name})
+	}
+	for len(entries) > 0 {
+		entry := entries[0]
+		entries = entries[1:]
+		realName, ok := resolveLinks(linkTargets, entry.name)
+		if !ok || entry.depth >= maxLinkHops {
+			continue
+		}
+		if blob, ok := regularBlobs[gfs.pathOf(realName)]; ok {
+			gfs.blobs[gfs.pathOf(entry.name)] = blob
+			continue
+		}
+		// Links to the ancestors would be endless.
+		if realName == "." || strings.HasPrefix(entry.name+"/", realName+"/") {
+			continue
+		}
+		realPrefix := gfs.pathOf(realName) + filesys.Separator
+		for blobPath, blob := range regularBlobs {
+			if strings.HasPrefix(blobPath, realPrefix) {
+				gfs.blobs[gfs.pathOf(entry.name)+filesys.Separator+blobPath[len(realPrefix):]] = blob
+			}
+		}
+		// The links in the directory are resolved under the link.
+		for _, name := range linkNames {
+			if strings.HasPrefix(name, realName+"/") {
+				entries = append(entries, linkEntry{name: entry.name + name[len(realName):], depth: entry.depth + 1})
+			}
+		}
+	}
+	return nil
+}
+
+// resolveLinks replaces the links in the path with their targets until no
+// link is left. It returns false if the path is out of the tree or the links
+// are too deep.
+func resolveLinks(linkTargets map[string]string, name string) (string, bool) {
+	for hops := 0; hops <= maxLinkHops; hops++ {
+		if name == ".." || strings.HasPrefix(name, "../") || path.IsAbs(name) {
+			return "", false
+		}
+		resolved := true
+		parts := strings.Split(name, "/")
+		for i := range parts {
+			if linkTarget, ok := linkTargets[strings.Join(parts[:i+1], "/")]; ok {
+				name = path.Join(append([]string{linkTarget}, parts[i+1:]...)...)
+				resolved = false
+				break
+			}
+		}
+		if resolved {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+func (gfs *GitTreeFs) pathOf(name string) string {
+	return filepath.Join(filesys.Separator, filepath.FromSlash(name))
+}
+
+func (gfs *GitTreeFs) catBlob(blob gitBlob) ([]byte, error) {
+	stdout, _, err := blob.gitDir.RunGitCommand(gfs.ctx, "cat-file", "blob", blob.object)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(stdout), nil
+}
+
+// load reads the content of the file from the object database if it has not been read yet.
+func (gfs *GitTreeFs) load(filePath string) error {
+	gfs.mu.Lock()
+	defer gfs.mu.Unlock()
+	filePath = filepath.Clean(filePath)
+	blob, ok := gfs.blobs[filePath]
+	if !ok {
+		return nil
+	}
+	content, err := gfs.catBlob(blob)
+	if err != nil {
+		return err
+	}
+	err = gfs.FileSystem.WriteFile(filePath, content)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	delete(gfs.blobs, filePath)
+	return nil
+}
+
+func (gfs *GitTreeFs) forget(filePath string) {
+	gfs.mu.Lock()
+	defer gfs.mu.Unlock()
+	filePath = filepath.Clean(filePath)
+	for blobPath := range gfs.blobs {
+		if blobPath == filePath || strings.HasPrefix(blobPath, filePath+filesys.Separator) {
+			delete(gfs.blobs, blobPath)
+		}
+	}
+}
+
+func (gfs *GitTreeFs) Open(path string) (filesys.File, error) {
+	err := gfs.load(path)
+	if err != nil {
+		return nil, err
+	}
+	return gfs.FileSystem.Open(path)
+}
+
+func (gfs *GitTreeFs) ReadFile(path string) ([]byte, error) {
+	err := gfs.load(path)
+	if err != nil {
+		return nil, err
+	}
+	return gfs.FileSystem.ReadFile(path)
+}
+
+func (gfs *GitTreeFs) Create(path string) (filesys.File, error) {
+	gfs.forget(path)
+	return gfs.FileSystem.Create(path)
+}
+
+func (gfs *GitTreeFs) WriteFile(path string, data []byte) error {
+	gfs.forget(path)
+	return gfs.FileSystem.WriteFile(path, data)
+}
+
+func (gfs *GitTreeFs) RemoveAll(path string) error {
+	gfs.forget(path)
+	return gfs.FileSystem.RemoveAll(path)
+}
@@ -0,0 +1,136 @@
+/*
+Copyright 2021 Daisuke Taniwaki.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGitTreeFs(t *testing.T) {
+	tmpGitDir, err := ioutil.TempDir("", "kustomize-diff-test-")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer os.RemoveAll(tmpGitDir)
+	gitDir := NewGitDir(tmpGitDir, "")
+	_, _, err = gitDir.RunGitCommand(context.Background(), "init", "-q")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	if !assert.NoError(t, gitDir.SetUser(context.Background())) {
+		t.FailNow()
+	}
+	for _, name := range []string{"a", "b"} {
+		src := filepath.Join("fixtures", "kustomize", name)
+		dst := filepath.Join(tmpGitDir, "kustomize", name)
+		if !assert.NoError(t, os.MkdirAll(dst, 0700)) {
+			t.FailNow()
+		}
+		for _, file := range []string{"kustomization.yaml", "pod.yaml"} {
+			bs, err := ioutil.ReadFile(filepath.Join(src, file))
+			if !assert.NoError(t, err) {
+				t.FailNow()
+			}
+			if !assert.NoError(t, ioutil.WriteFile(filepath.Join(dst, file), bs, 0600)) {
+				t.FailNow()
+			}
+		}
+	}
+	for link, linkTarget := range map[string]string{
+		"link.yaml":       "a/pod.yaml",
+		"chain.yaml":      "link.yaml",
+		"c":               "a",
+		"dir-link.yaml":   "c/pod.yaml",
+		"self":            ".",
+		"outside.yaml":    "../../outside.yaml",
+		"absolute.yaml":   "/etc/hostname",
+		"loop-a.yaml":     "loop-b.yaml",
+		"loop-b.yaml":     "loop-a.yaml",
+		"d":               "c",
+		"d-sub/link.yaml": "../d/kustomization.yaml",
+	} {
+		linkPath := filepath.Join(tmpGitDir, "kustomize", link)
+		if !assert.NoError(t, os.MkdirAll(filepath.Dir(linkPath), 0700)) {
+			t.FailNow()
+		}
+		if !assert.NoError(t, os.Symlink(linkTarget, linkPath)) {
+			t.FailNow()
+		}
+	}
+	_, _, err = gitDir.RunGitCommand(context.Background(), "add", "-A")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	_, _, err = gitDir.RunGitCommand(context.Background(), "commit", "-q", "-m", "init")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	// Make sure the contents are read from the git objects.
+	if !assert.NoError(t, os.RemoveAll(filepath.Join(tmpGitDir, "kustomize"))) {
+		t.FailNow()
+	}
+
+	fSys, err := NewGitTreeFs(context.Background(), gitDir, "HEAD")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	assert.True(t, fSys.IsDir("/kustomize/a"))
+	assert.True(t, fSys.Exists("/kustomize/b/pod.yaml"))
+	assert.False(t, fSys.Exists("/kustomize/e"))
+
+	expected, _ := ioutil.ReadFile(filepath.Join("fixtures", "kustomize", "a", "pod.yaml"))
+	actual, err := fSys.ReadFile("/kustomize/a/pod.yaml")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	assert.Equal(t, string(expected), string(actual))
+	for _, name := range []string{"link.yaml", "chain.yaml", "c/pod.yaml", "dir-link.yaml", "d/pod.yaml"} {
+		actual, err = fSys.ReadFile("/kustomize/" + name)
+		if !assert.NoError(t, err, name) {
+			t.FailNow()
+		}
+		assert.Equal(t, string(expected), string(actual), name)
+	}
+	// The links to directories are directories.
+	assert.True(t, fSys.IsDir("/kustomize/c"))
+	assert.True(t, fSys.Exists("/kustomize/d-sub/link.yaml"))
+	// The links out of the tree, to the ancestors or in loops are dropped.
+	for _, name := range []string{"self", "outside.yaml", "absolute.yaml", "loop-a.yaml", "loop-b.yaml"} {
+		assert.False(t, fSys.Exists("/kustomize/"+name), name)
+	}
+
+	if !assert.NoError(t, fSys.WriteFile("/kustomize/b/pod.yaml", []byte("modified"))) {
+		t.FailNow()
+	}
+	actual, err = fSys.ReadFile("/kustomize/b/pod.yaml")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	assert.Equal(t, "modified", string(actual))
+
+	dirs, err := ListKustomizeDirs("/kustomize", ListKustomizeDirsOpts{FileSystem: fSys})
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	assert.Equal(t, []string{"a", "b", "c", "d"}, dirs)
+}
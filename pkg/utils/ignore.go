@@ -0,0 +1,210 @@
+/*
+Copyright 2021 Daisuke Taniwaki.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"sigs.k8s.io/kustomize/kyaml/filesys"
+)
+
+// IgnoreFileNames are the names of the files with gitignore-style patterns
+// honored while listing directories.
+var IgnoreFileNames = []string{".gitignore", ".kustomizediffignore"}
+
+// DefaultIgnorePatterns are applied at the root of a listing.
+// They can be negated in the ignore files.
+var DefaultIgnorePatterns = []string{"node_modules/", "vendor/"}
+
+type ignorePattern struct {
+	baseDir  string
+	segments []string
+	negate   bool
+	dirOnly  bool
+	anchored bool
+}
+
+func parseIgnorePattern(baseDir, line string) *ignorePattern {
+	line = strings.TrimRight(line, " \t\r")
+	if line == "" || strings.HasPrefix(line, "#") {
+		return nil
+	}
+	p := &ignorePattern{baseDir: baseDir}
+	if strings.HasPrefix(line, "!") {
+		p.negate = true
+		line = line[1:]
+	} else if strings.HasPrefix(line, "\\") {
+		line = line[1:]
+	}
+	if strings.HasSuffix(line, "/") {
+		p.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+	// A pattern with a slash is relative to the directory of the ignore file.
+	p.anchored = strings.Contains(line, "/")
+	line = strings.TrimPrefix(line, "/")
+	if line == "" {
+		return nil
+	}
+	p.segments = strings.Split(line, "/")
+	return p
+}
+
+func (p *ignorePattern) match(filePath string, isDir bool) bool {
+	if p.dirOnly && !isDir {
+		return false
+	}
+	relPath, err := filepath.Rel(p.baseDir, filePath)
+	if err != nil || relPath == "." || strings.HasPrefix(relPath, "..") {
+		return false
+	}
+	segments := strings.Split(filepath.ToSlash(relPath), "/")
+	if !p.anchored {
+		return matchSegments(p.segments, segments[len(segments)-1:])
+	}
+	return matchSegments(p.segments, segments)
+}
+
+func matchSegments(patterns, segments []string) bool {
+	if len(patterns) == 0 {
+		return len(segments) == 0
+	}
+	if patterns[0] == "**" {
+		for i := 0; i <= len(segments); i++ {
+			if matchSegments(patterns[1:], segments[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(segments) == 0 {
+		return false
+	}
+	m, err := path.Match(patterns[0], segments[0])
+	if err != nil || !m {
+		return false
+	}
+	return matchSegments(patterns[1:], segments[1:])
+}
+
+// ignoreList is an ordered list of patterns where the last match wins.
+type ignoreList []*ignorePattern
+
+func (il ignoreList) withPatterns(baseDir string, lines []string) ignoreList {
+	newList := append(ignoreList{}, il...)
+	for _, line := range lines {
+		if p := parseIgnorePattern(baseDir, line); p != nil {
+			newList = append(newList, p)
+		}
+	}
+	return newList
+}
+
+func (il ignoreList) withIgnoreFiles(fSys filesys.FileSystem, dirPath string) (ignoreList, error) {
+	newList := il
+	for _, name := range IgnoreFileNames {
+		filePath := filepath.Join(dirPath, name)
+		if !fSys.Exists(filePath) || fSys.IsDir(filePath) {
+			continue
+		}
+		bs, err := fSys.ReadFile(filePath)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		newList = newList.withPatterns(dirPath, strings.Split(string(bs), "\n"))
+	}
+	return newList, nil
+}
+
+func (il ignoreList) ignored(filePath string, isDir bool) bool {
+	for i := len(il) - 1; i >= 0; i-- {
+		if il[i].match(filePath, isDir) {
+			return !il[i].negate
+		}
+	}
+	return false
+}
+
+// kustomizationIgnored returns true if all the kustomization files in the
+// directory are ignored.
+func (il ignoreList) kustomizationIgnored(fSys filesys.FileSystem, dirPath string) bool {
+	files := KustomizationFiles(fSys, dirPath)
+	for _, name := range files {
+		if !il.ignored(filepath.Join(dirPath, name), false) {
+			return false
+		}
+	}
+	return len(files) > 0
+}
+
+// walkDirs calls walkFn for the directory and its descendant directories in
+// lexical order with the ignore patterns in effect in the directory. .git
+// directories and directories matched by the ignore files are skipped.
+// Symbolic links are followed unless they point outside of the root directory
+// or to an ancestor directory.
+func walkDirs(fSys filesys.FileSystem, rootDirPath string, walkFn func(dirPath string, il ignoreList) error) error {
+	rootDir, _, err := fSys.CleanedAbs(rootDirPath)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	ancestors := make(map[filesys.ConfirmedDir]struct{})
+	var walk func(dirPath string, il ignoreList) error
+	walk = func(dirPath string, il ignoreList) error {
+		realDir, _, err := fSys.CleanedAbs(dirPath)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		if _, ok := ancestors[realDir]; ok {
+			return nil
+		}
+		if !realDir.HasPrefix(rootDir) {
+			return nil
+		}
+		ancestors[realDir] = struct{}{}
+		defer delete(ancestors, realDir)
+
+		il, err = il.withIgnoreFiles(fSys, dirPath)
+		if err != nil {
+			return err
+		}
+		err = walkFn(dirPath, il)
+		if err != nil {
+			return err
+		}
+		names, err := fSys.ReadDir(dirPath)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			childPath := filepath.Join(dirPath, name)
+			if name == ".git" || !fSys.IsDir(childPath) || il.ignored(childPath, true) {
+				continue
+			}
+			err = walk(childPath, il)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return walk(rootDirPath, ignoreList{}.withPatterns(rootDirPath, DefaultIgnorePatterns))
+}
@@ -0,0 +1,89 @@
+/*
+Copyright 2021 Daisuke Taniwaki.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIgnorePattern(t *testing.T) {
+	p := parseIgnorePattern("/repo", "fixtures/")
+	assert.True(t, p.match("/repo/fixtures", true))
+	assert.True(t, p.match("/repo/a/b/fixtures", true))
+	assert.False(t, p.match("/repo/fixtures", false))
+	assert.False(t, p.match("/other/fixtures", true))
+
+	p = parseIgnorePattern("/repo", "/tmp-*")
+	assert.True(t, p.match("/repo/tmp-a", true))
+	assert.False(t, p.match("/repo/a/tmp-a", true))
+
+	p = parseIgnorePattern("/repo", "a/**/test")
+	assert.True(t, p.match("/repo/a/test", true))
+	assert.True(t, p.match("/repo/a/b/c/test", true))
+	assert.False(t, p.match("/repo/b/test", true))
+
+	p = parseIgnorePattern("/repo", "!vendor")
+	assert.True(t, p.negate)
+
+	assert.Nil(t, parseIgnorePattern("/repo", "# comment"))
+	assert.Nil(t, parseIgnorePattern("/repo", ""))
+}
+
+func TestListKustomizeDirsIgnore(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "kustomize-diff-test-")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	defer os.RemoveAll(tmpDir)
+	rootDir := filepath.Join(tmpDir, "root")
+	writeFile := func(path, content string) {
+		path = filepath.Join(rootDir, path)
+		if !assert.NoError(t, os.MkdirAll(filepath.Dir(path), 0700)) {
+			t.FailNow()
+		}
+		if !assert.NoError(t, ioutil.WriteFile(path, []byte(content), 0600)) {
+			t.FailNow()
+		}
+	}
+	for _, dir := range []string{"app", ".git/app", "node_modules/app", "vendor/app", "test/fixtures/app", "tmp-app", "skip", "own"} {
+		writeFile(filepath.Join(dir, "kustomization.yaml"), "")
+	}
+	writeFile(".kustomizediffignore", "/tmp-*\n!vendor/\n/skip/kustomization.yaml\n")
+	writeFile("own/.kustomizediffignore", "kustomization.yaml\n")
+	writeFile("test/.gitignore", "fixtures/\n")
+	writeFile("../other/kustomization.yaml", "")
+	for link, target := range map[string]string{"link": "app", "loop": ".", "outside": "../other"} {
+		if !assert.NoError(t, os.Symlink(target, filepath.Join(rootDir, link))) {
+			t.FailNow()
+		}
+	}
+
+	dirs, err := ListKustomizeDirs(rootDir, ListKustomizeDirsOpts{})
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	assert.Equal(t, []string{
+		"app",
+		"link",
+		"vendor/app",
+	}, dirs)
+}
@@ -18,30 +18,58 @@ package utils
 
 import (
 	"fmt"
-	"io/fs"
-	"os"
 	"path/filepath"
 	"regexp"
+	"strings"
 
 	"github.com/pkg/errors"
+	"sigs.k8s.io/kustomize/api/konfig"
+	"sigs.k8s.io/kustomize/api/types"
+	"sigs.k8s.io/kustomize/kyaml/filesys"
 )
 
 type ListKustomizeDirsOpts struct {
 	IncludeRegexp *regexp.Regexp
 	ExcludeRegexp *regexp.Regexp
+	FileSystem    filesys.FileSystem
+	// Detect returns true if the directory is a target. Defaults to KustomizationExistsInFs.
+	Detect func(fSys filesys.FileSystem, path string) bool
+	// LeafOnly excludes kustomizations referred by other kustomizations. Only
+	// the kustomizations under the listed directory are looked at, so a
+	// kustomization referred only from outside of it is still listed.
+	LeafOnly bool
 }
 
+// ListKustomizeDirs returns the directories of the targets relative to the directory.
+// See walkDirs for the directories to be skipped.
 func ListKustomizeDirs(dirPath string, opts ListKustomizeDirsOpts) ([]string, error) {
-	targetFiles := make([]string, 0)
-	err := filepath.WalkDir(dirPath, func(path string, d fs.DirEntry, err error) error {
-		if err != nil {
-			return errors.WithStack(err)
-		}
-		if !d.IsDir() {
+	fSys := opts.FileSystem
+	if fSys == nil {
+		fSys = filesys.MakeFsOnDisk()
+	}
+	detect := opts.Detect
+	if detect == nil {
+		detect = KustomizationExistsInFs
+	}
+	targetPaths := make([]string, 0)
+	referredPaths := make(map[string]struct{})
+	err := walkDirs(fSys, dirPath, func(path string, il ignoreList) error {
+		// The directories of the ignored kustomization files are skipped too.
+		if !detect(fSys, path) || il.kustomizationIgnored(fSys, path) {
 			return nil
 		}
-		if !KustomizationExists(path) {
-			return nil
+		if KustomizationExistsInFs(fSys, path) {
+			// Broken kustomizations are listed to report their build errors.
+			k, err := ReadKustomization(fSys, path)
+			if err == nil {
+				for _, refPath := range KustomizationRefs(k, path) {
+					referredPaths[refPath] = struct{}{}
+				}
+				// Components can't be built by themselves.
+				if k.Kind == types.ComponentKind {
+					return nil
+				}
+			}
 		}
 		included := true
 		if opts.IncludeRegexp != nil {
@@ -59,37 +87,109 @@ func ListKustomizeDirs(dirPath string, opts ListKustomizeDirsOpts) ([]string, er
 			}
 		}
 		if included {
-			relPath, err := filepath.Rel(dirPath, path)
-			if err != nil {
-				return errors.WithStack(err)
-			}
-			targetFiles = append(targetFiles, relPath)
+			targetPaths = append(targetPaths, path)
 		}
 		return nil
 	})
 	if err != nil {
 		return nil, err
 	}
+	targetFiles := make([]string, 0, len(targetPaths))
+	for _, path := range targetPaths {
+		if _, ok := referredPaths[filepath.Clean(path)]; ok && opts.LeafOnly {
+			continue
+		}
+		relPath, err := filepath.Rel(dirPath, path)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		targetFiles = append(targetFiles, relPath)
+	}
 	return targetFiles, nil
 }
 
+// ReadKustomization reads the kustomization file in the directory.
+func ReadKustomization(fSys filesys.FileSystem, path string) (*types.Kustomization, error) {
+	files := KustomizationFiles(fSys, path)
+	if len(files) == 0 {
+		return nil, fmt.Errorf("Kustomization not found: %s", path)
+	}
+	bs, err := fSys.ReadFile(filepath.Join(path, files[0]))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	k := &types.Kustomization{}
+	err = k.Unmarshal(bs)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	k.FixKustomizationPostUnmarshalling()
+	return k, nil
+}
+
+// KustomizationRefs returns the cleaned paths of the resources and components
+// of the kustomization in the directory. Remote references never match a local path.
+func KustomizationRefs(k *types.Kustomization, path string) []string {
+	refPaths := make([]string, 0, len(k.Resources)+len(k.Components))
+	for _, ref := range append(append([]string{}, k.Resources...), k.Components...) {
+		refPaths = append(refPaths, filepath.Clean(filepath.Join(path, ref)))
+	}
+	return refPaths
+}
+
+// KustomizationPluginRefs returns the cleaned paths of the generators,
+// transformers and validators of the kustomization in the directory. The
+// inline configs are skipped.
+func KustomizationPluginRefs(k *types.Kustomization, path string) []string {
+	refPaths := make([]string, 0, len(k.Generators)+len(k.Transformers)+len(k.Validators))
+	for _, refs := range [][]string{k.Generators, k.Transformers, k.Validators} {
+		for _, ref := range refs {
+			if strings.Contains(ref, "\n") {
+				continue
+			}
+			refPaths = append(refPaths, filepath.Clean(filepath.Join(path, ref)))
+		}
+	}
+	return refPaths
+}
+
 func KustomizationExists(path string) bool {
-	return Exists(filepath.Join(path, "kustomization.yaml")) || Exists(filepath.Join(path, "kustomization.yml"))
+	return KustomizationExistsInFs(filesys.MakeFsOnDisk(), path)
+}
+
+func KustomizationExistsInFs(fSys filesys.FileSystem, path string) bool {
+	return len(KustomizationFiles(fSys, path)) > 0
+}
+
+// KustomizationFiles returns the kustomization file names recognized by kustomize in the directory.
+// kustomize fails to build a directory with multiple kustomization files.
+func KustomizationFiles(fSys filesys.FileSystem, path string) []string {
+	files := make([]string, 0)
+	for _, name := range konfig.RecognizedKustomizationFileNames() {
+		filePath := filepath.Join(path, name)
+		if fSys.Exists(filePath) && !fSys.IsDir(filePath) {
+			files = append(files, name)
+		}
+	}
+	return files
 }
 
 func MakeKustomizeDir(dirPath string) error {
-	err := os.MkdirAll(dirPath, 0700)
+	return MakeKustomizeDirInFs(filesys.MakeFsOnDisk(), dirPath)
+}
+
+func MakeKustomizeDirInFs(fSys filesys.FileSystem, dirPath string) error {
+	err := fSys.MkdirAll(dirPath)
 	if err != nil {
 		return errors.WithStack(err)
 	}
-	kustomizationFilePath := filepath.Join(dirPath, "kustomization.yaml")
-	if Exists(kustomizationFilePath) {
-		return fmt.Errorf("File already exists: %s", kustomizationFilePath)
+	if files := KustomizationFiles(fSys, dirPath); len(files) > 0 {
+		return fmt.Errorf("File already exists: %s", filepath.Join(dirPath, files[0]))
 	}
-	f, err := os.Create(kustomizationFilePath)
+	kustomizationFilePath := filepath.Join(dirPath, konfig.DefaultKustomizationFileName())
+	err = fSys.WriteFile(kustomizationFilePath, []byte{})
 	if err != nil {
 		return errors.WithStack(err)
 	}
-	defer f.Close()
 	return nil
 }
@@ -23,6 +23,7 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"sigs.k8s.io/kustomize/kyaml/filesys"
 )
 
 func TestListKustomizeDirs(t *testing.T) {
@@ -35,6 +36,8 @@ func TestListKustomizeDirs(t *testing.T) {
 	assert.Equal(t, []string{
 		"a",
 		"b",
+		"c",
+		"d",
 	}, dirs)
 
 	includeRegexp, _ := regexp.Compile(".*/a$")
@@ -53,5 +56,46 @@ func TestListKustomizeDirs(t *testing.T) {
 	}
 	assert.Equal(t, []string{
 		"b",
+		"c",
+		"d",
 	}, dirs)
 }
+
+func TestListKustomizeDirsLeafOnly(t *testing.T) {
+	wd, _ := os.Getwd()
+
+	dirs, err := ListKustomizeDirs(filepath.Join(wd, "fixtures", "leaf"), ListKustomizeDirsOpts{})
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	assert.Equal(t, []string{
+		"base",
+		"overlays/prod",
+	}, dirs)
+
+	dirs, err = ListKustomizeDirs(filepath.Join(wd, "fixtures", "leaf"), ListKustomizeDirsOpts{LeafOnly: true})
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	assert.Equal(t, []string{
+		"overlays/prod",
+	}, dirs)
+
+	// Kustomizations referred by excluded ones are still excluded.
+	excludeRegexp, _ := regexp.Compile("/overlays/")
+	dirs, err = ListKustomizeDirs(filepath.Join(wd, "fixtures", "leaf"), ListKustomizeDirsOpts{LeafOnly: true, ExcludeRegexp: excludeRegexp})
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	assert.Equal(t, []string{}, dirs)
+}
+
+func TestKustomizationFiles(t *testing.T) {
+	wd, _ := os.Getwd()
+	fSys := filesys.MakeFsOnDisk()
+
+	assert.Equal(t, []string{"kustomization.yaml"}, KustomizationFiles(fSys, filepath.Join(wd, "fixtures", "kustomize", "a")))
+	assert.Equal(t, []string{"Kustomization"}, KustomizationFiles(fSys, filepath.Join(wd, "fixtures", "kustomize", "c")))
+	assert.Equal(t, []string{"kustomization.yaml", "kustomization.yml"}, KustomizationFiles(fSys, filepath.Join(wd, "fixtures", "kustomize", "d")))
+	assert.Equal(t, []string{}, KustomizationFiles(fSys, filepath.Join(wd, "fixtures", "kustomize")))
+}
@@ -17,6 +17,7 @@ limitations under the License.
 package utils
 
 import (
+	"context"
 	"io/ioutil"
 	"os"
 	"strings"
@@ -24,7 +25,7 @@ import (
 	"github.com/pkg/errors"
 )
 
-func Diff(text1, text2 string) (string, error) {
+func Diff(ctx context.Context, text1, text2 string) (string, error) {
 	tmpFile1, err := ioutil.TempFile("", "git-kustomize-diff-diff-")
 	if err != nil {
 		return "", errors.WithStack(err)
@@ -51,7 +52,7 @@ func Diff(text1, text2 string) (string, error) {
 		return "", errors.WithStack(err)
 	}
 
-	stdout, _, err := (&WorkDir{}).RunCommand("diff", "-u", tmpFile1.Name(), tmpFile2.Name())
+	stdout, _, err := (&WorkDir{}).RunCommand(ctx, "diff", "-u", tmpFile1.Name(), tmpFile2.Name())
 	if err != nil {
 		if GetExitCode(err) == nil {
 			return "", errors.WithStack(err)
@@ -17,6 +17,7 @@ limitations under the License.
 package utils
 
 import (
+	"context"
 	"strings"
 	"testing"
 
@@ -32,13 +33,13 @@ func TestDiff(t *testing.T) {
 +d
 `, "\n")
 
-	diff, err := Diff("a\nb\nc\n", "a\nc\nd\n")
+	diff, err := Diff(context.Background(), "a\nb\nc\n", "a\nc\nd\n")
 	if !assert.NoError(t, err) {
 		t.FailNow()
 	}
 	assert.Equal(t, expectedDiff, diff)
 
-	diff, err = Diff("a\nb\nc\n", "a\nb\nc\n")
+	diff, err = Diff(context.Background(), "a\nb\nc\n", "a\nb\nc\n")
 	if !assert.NoError(t, err) {
 		t.FailNow()
 	}